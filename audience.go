@@ -0,0 +1,128 @@
+package GoogleIdTokenVerifier
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+)
+
+// Audience is the "aud" claim of a token. Per OIDC it can be either a single
+// string or an array of strings, both forms are accepted when decoding.
+type Audience []string
+
+// UnmarshalJSON accepts both a bare string and an array of strings
+func (a *Audience) UnmarshalJSON(data []byte) error {
+	var single string
+	if err := json.Unmarshal(data, &single); err == nil {
+		*a = Audience{single}
+		return nil
+	}
+	var multi []string
+	if err := json.Unmarshal(data, &multi); err != nil {
+		return errors.New("aud must be a string or an array of strings")
+	}
+	*a = Audience(multi)
+	return nil
+}
+
+// MarshalJSON encodes a single audience as a bare string, as Google does
+func (a Audience) MarshalJSON() ([]byte, error) {
+	if len(a) == 1 {
+		return json.Marshal(a[0])
+	}
+	return json.Marshal([]string(a))
+}
+
+// String returns the audience for the common single value case, or all the
+// values separated by spaces when there are several of them
+func (a Audience) String() string {
+	return strings.Join(a, " ")
+}
+
+// Contains tells if aud is one of the audiences of the token
+func (a Audience) Contains(aud string) bool {
+	for _, tknAud := range a {
+		if tknAud == aud {
+			return true
+		}
+	}
+	return false
+}
+
+// AudienceMatchMode is the policy used to match the audiences of a token against the expected ones
+type AudienceMatchMode int
+
+const (
+	// AnyMatch accepts the token if any of its audiences is one of the expected ones
+	AnyMatch AudienceMatchMode = iota
+	// ExactSetMatch accepts the token only if its set of audiences equals the expected set.
+	// Tokens without audience are rejected, even when no audience is expected.
+	ExactSetMatch
+)
+
+func (mode AudienceMatchMode) match(tknAud Audience, auds []string) bool {
+	switch mode {
+	case ExactSetMatch:
+		// two empty sets are equal, but a token without audience is never accepted
+		return len(tknAud) > 0 && toSet(tknAud).equals(toSet(auds))
+	default:
+		for _, aud := range auds {
+			if tknAud.Contains(aud) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// audienceMatcher tells if the audiences of a token are accepted
+type audienceMatcher func(tknAud Audience) bool
+
+// expecting returns the matcher of the tokens issued for auds
+func (mode AudienceMatchMode) expecting(auds ...string) audienceMatcher {
+	return func(tknAud Audience) bool {
+		return mode.match(tknAud, auds)
+	}
+}
+
+// accepting returns the matcher of the tokens whose audiences are accepted by audOK, any of them
+// with AnyMatch and all of them with ExactSetMatch
+func (mode AudienceMatchMode) accepting(audOK func(aud string) bool) audienceMatcher {
+	return func(tknAud Audience) bool {
+		if len(tknAud) == 0 {
+			return false
+		}
+		for _, aud := range tknAud {
+			ok := audOK(aud)
+			if ok && mode != ExactSetMatch {
+				return true
+			}
+			if !ok && mode == ExactSetMatch {
+				return false
+			}
+		}
+		return mode == ExactSetMatch
+	}
+}
+
+type stringSet map[string]struct{}
+
+func toSet(values []string) stringSet {
+	set := make(stringSet, len(values))
+	for _, v := range values {
+		set[v] = struct{}{}
+	}
+	return set
+}
+
+func (s stringSet) equals(other stringSet) bool {
+	if len(s) != len(other) {
+		return false
+	}
+	for v := range s {
+		if _, ok := other[v]; !ok {
+			return false
+		}
+	}
+	return true
+}
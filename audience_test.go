@@ -0,0 +1,126 @@
+package GoogleIdTokenVerifier
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAudienceJSON(t *testing.T) {
+	tests := []struct {
+		testName string
+		json     string
+		expAud   Audience
+		expError bool
+	}{
+		{"Bare string", `"client.apps.googleusercontent.com"`, Audience{"client.apps.googleusercontent.com"}, false},
+		{"Array", `["one", "two"]`, Audience{"one", "two"}, false},
+		{"Single element array", `["one"]`, Audience{"one"}, false},
+		{"Number", `12345`, nil, true},
+		{"Array of numbers", `[1, 2]`, nil, true},
+	}
+
+	for _, tc := range tests {
+		// nolint
+		t.Run(tc.testName, func(t *testing.T) {
+			var aud Audience
+			err := json.Unmarshal([]byte(tc.json), &aud)
+			if tc.expError {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.expAud, aud)
+
+			encoded, err := json.Marshal(aud)
+			require.NoError(t, err)
+			var decoded Audience
+			require.NoError(t, json.Unmarshal(encoded, &decoded))
+			assert.Equal(t, aud, decoded)
+		})
+	}
+
+	assert.Equal(t, "one", Audience{"one"}.String())
+	assert.True(t, Audience{"one", "two"}.Contains("two"))
+	assert.False(t, Audience{"one", "two"}.Contains("three"))
+}
+
+func TestVerifyArrayAudience(t *testing.T) {
+	verifier := New(&StaticCertsProvider{certs: loadTestCerts(t)})
+	claims := testClaims(time.Now())
+	claims["aud"] = []string{"other.apps.googleusercontent.com", testAud}
+
+	tokeninfo := verifier.Verify(signTestToken(t, claims), testAud)
+	require.NotNil(t, tokeninfo)
+	assert.Equal(t, Audience{"other.apps.googleusercontent.com", testAud}, tokeninfo.Aud)
+	assert.Nil(t, verifier.Verify(signTestToken(t, claims), "unknown.apps.googleusercontent.com"))
+}
+
+func TestVerifyFunc(t *testing.T) {
+	ownClientID := func(aud string) bool {
+		return strings.HasSuffix(aud, "-ours.apps.googleusercontent.com")
+	}
+	certs := loadTestCerts(t)
+	anyMatch := New(&StaticCertsProvider{certs: certs})
+	exactSetMatch := New(&StaticCertsProvider{certs: certs}, WithAudienceMatchMode(ExactSetMatch))
+
+	tests := []struct {
+		testName string
+		verifier *GoogleTokenVerifier
+		aud      interface{}
+		expValid bool
+	}{
+		{"Own client ID", anyMatch, "1234-ours.apps.googleusercontent.com", true},
+		{"Other client ID", anyMatch, "1234-theirs.apps.googleusercontent.com", false},
+		{"Suffix in the middle", anyMatch, "1234-ours.apps.googleusercontent.com.evil.com", false},
+		{"Any of several audiences", anyMatch, []string{"1234-theirs.apps.googleusercontent.com", "1234-ours.apps.googleusercontent.com"}, true},
+		{"No audience", anyMatch, []string{}, false},
+		{"Exact set of own client IDs", exactSetMatch, []string{"1234-ours.apps.googleusercontent.com", "5678-ours.apps.googleusercontent.com"}, true},
+		{"Exact set with other client ID", exactSetMatch, []string{"1234-theirs.apps.googleusercontent.com", "1234-ours.apps.googleusercontent.com"}, false},
+	}
+
+	for _, tc := range tests {
+		// nolint
+		t.Run(tc.testName, func(t *testing.T) {
+			claims := testClaims(time.Now())
+			claims["aud"] = tc.aud
+			tokeninfo, err := tc.verifier.VerifyFunc(signTestToken(t, claims), ownClientID)
+			if tc.expValid {
+				require.NoError(t, err)
+				assert.NotNil(t, tokeninfo)
+				return
+			}
+			assert.Nil(t, tokeninfo)
+			assert.True(t, errors.Is(err, ErrAudienceMismatch), "got %v", err)
+		})
+	}
+
+	// the audience of forged tokens is not looked at
+	claims := testClaims(time.Now())
+	claims["aud"] = "1234-ours.apps.googleusercontent.com"
+	authToken := signTestToken(t, claims)
+	forged := authToken[:len(authToken)-4] + "AAAA"
+	called := false
+	_, err := anyMatch.VerifyFunc(forged, func(aud string) bool {
+		called = true
+		return true
+	})
+	assert.True(t, errors.Is(err, ErrSignatureInvalid), "got %v", err)
+	assert.False(t, called)
+
+	// nor the one of tokens failing other checks
+	claims = testClaims(time.Now().Add(-2 * time.Hour))
+	claims["aud"] = "1234-ours.apps.googleusercontent.com"
+	calls := 0
+	_, err = anyMatch.VerifyFunc(signTestToken(t, claims), func(aud string) bool {
+		calls++
+		return true
+	})
+	assert.True(t, errors.Is(err, ErrTokenExpired), "got %v", err)
+	assert.Zero(t, calls)
+}
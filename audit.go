@@ -0,0 +1,92 @@
+package GoogleIdTokenVerifier
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// AuditResultValid is the result of an AuditRecord of a token that was verified
+const AuditResultValid = "valid"
+
+// AuditRecord is a tamper-evident record of a verification, MAC is an HMAC-SHA256 of its
+// other fields. Subject and Issuer are empty when the token could not be verified.
+type AuditRecord struct {
+	Subject   string
+	Audience  string
+	Issuer    string
+	Timestamp time.Time
+	// Result is AuditResultValid or the error of the verification
+	Result string
+	MAC    []byte
+}
+
+// VerifyWithAuditRecord is VerifyE that also returns an AuditRecord of the verification signed with hmacKey
+func (v *GoogleTokenVerifier) VerifyWithAuditRecord(authToken string, aud string, hmacKey []byte) (*TokenInfo, AuditRecord, error) {
+	tokeninfo, err := v.VerifyE(authToken, aud)
+	record := AuditRecord{
+		Audience:  aud,
+		Timestamp: v.clock.Now().UTC(),
+		Result:    AuditResultValid,
+	}
+	if err != nil {
+		record.Result = err.Error()
+	} else {
+		record.Subject = tokeninfo.Sub
+		record.Issuer = tokeninfo.Iss
+	}
+	record.MAC = record.computeMAC(hmacKey)
+	return tokeninfo, record, err
+}
+
+// VerificationResult describes a successful verification with structured data for audit pipelines,
+// along with the claims of the token
+type VerificationResult struct {
+	*TokenInfo
+	// MatchedKeyID is the kid of the key that verified the signature, which is not the kid of the
+	// token header when another key was found by WithTrialVerification
+	MatchedKeyID string
+	// Issuer is the issuer of the token, one of the accepted ones
+	Issuer string
+	// Audience is the audience the token was verified for
+	Audience string
+	// VerifiedAt is the time of the verification according to the clock of the verifier
+	VerifiedAt time.Time
+}
+
+// VerifyDetailed verifies authToken like VerifyE does, and describes the verification
+func (v *GoogleTokenVerifier) VerifyDetailed(authToken string, aud string) (*VerificationResult, error) {
+	tokeninfo, err := v.VerifyE(authToken, aud)
+	if err != nil {
+		return nil, err
+	}
+	return &VerificationResult{
+		TokenInfo:    tokeninfo,
+		MatchedKeyID: tokeninfo.keyID,
+		Issuer:       tokeninfo.Iss,
+		Audience:     aud,
+		VerifiedAt:   v.clock.Now(),
+	}, nil
+}
+
+// Verify tells if the MAC of the record matches its fields for hmacKey
+func (r AuditRecord) Verify(hmacKey []byte) bool {
+	return hmac.Equal(r.MAC, r.computeMAC(hmacKey))
+}
+
+func (r AuditRecord) computeMAC(hmacKey []byte) []byte {
+	mac := hmac.New(sha256.New, hmacKey)
+	_, _ = mac.Write([]byte(r.canonical()))
+	return mac.Sum(nil)
+}
+
+// canonical encodes the fields of the record unambiguously, each one prefixed with its length
+func (r AuditRecord) canonical() string {
+	var b strings.Builder
+	for _, field := range []string{r.Subject, r.Audience, r.Issuer, r.Timestamp.UTC().Format(time.RFC3339Nano), r.Result} {
+		fmt.Fprintf(&b, "%d:%s", len(field), field)
+	}
+	return b.String()
+}
@@ -0,0 +1,70 @@
+package GoogleIdTokenVerifier
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestVerifyWithAuditRecord(t *testing.T) {
+	verifier := New(&StaticCertsProvider{certs: loadTestCerts(t)})
+	hmacKey := []byte("audit secret")
+
+	tokeninfo, record, err := verifier.VerifyWithAuditRecord(signTestToken(t, testClaims(time.Now())), testAud, hmacKey)
+	require.NoError(t, err)
+	require.NotNil(t, tokeninfo)
+	assert.Equal(t, tokeninfo.Sub, record.Subject)
+	assert.Equal(t, testAud, record.Audience)
+	assert.Equal(t, "https://accounts.google.com", record.Issuer)
+	assert.Equal(t, AuditResultValid, record.Result)
+	assert.WithinDuration(t, time.Now(), record.Timestamp, time.Minute)
+	assert.True(t, record.Verify(hmacKey))
+	assert.False(t, record.Verify([]byte("other secret")))
+
+	tampered := record
+	tampered.Subject = "208426113748299532117"
+	assert.False(t, tampered.Verify(hmacKey))
+	tampered = record
+	tampered.Timestamp = record.Timestamp.Add(time.Second)
+	assert.False(t, tampered.Verify(hmacKey))
+
+	tokeninfo, record, err = verifier.VerifyWithAuditRecord(signTestToken(t, testClaims(time.Now().Add(-2*time.Hour))), testAud, hmacKey)
+	assert.Error(t, err)
+	assert.Nil(t, tokeninfo)
+	assert.Empty(t, record.Subject)
+	assert.Equal(t, err.Error(), record.Result)
+	assert.True(t, record.Verify(hmacKey))
+	tampered = record
+	tampered.Result = AuditResultValid
+	assert.False(t, tampered.Verify(hmacKey))
+}
+
+func TestVerifyDetailed(t *testing.T) {
+	now := time.Now().Truncate(time.Second)
+	certs := loadTestCerts(t)
+	verifier := New(&StaticCertsProvider{certs: certs}, WithClock(fixedClock(now)))
+
+	result, err := verifier.VerifyDetailed(signTestToken(t, testClaims(now)), testAud)
+	require.NoError(t, err)
+	assert.Equal(t, "110169484474386276334", result.Sub)
+	assert.Equal(t, testKeyID, result.MatchedKeyID)
+	assert.Equal(t, "https://accounts.google.com", result.Issuer)
+	assert.Equal(t, testAud, result.Audience)
+	assert.Equal(t, now, result.VerifiedAt)
+	assert.Equal(t, now.Add(time.Hour), result.ExpiresAt())
+
+	// the key found by trial verification is the one reported
+	verifier = New(&StaticCertsProvider{certs: certs}, WithClock(fixedClock(now)), WithTrialVerification(2))
+	wrongKid := signTestTokenWithHeader(t, map[string]interface{}{"alg": "RS256", "kid": "unknown-kid"}, testClaims(now))
+	result, err = verifier.VerifyDetailed(wrongKid, testAud)
+	require.NoError(t, err)
+	assert.Equal(t, "unknown-kid", result.Header().Kid)
+	assert.Equal(t, testKeyID, result.MatchedKeyID)
+
+	result, err = verifier.VerifyDetailed(signTestToken(t, testClaims(now)), "other.apps.googleusercontent.com")
+	assert.True(t, errors.Is(err, ErrAudienceMismatch), "got %v", err)
+	assert.Nil(t, result)
+}
@@ -1,15 +1,151 @@
 package GoogleIdTokenVerifier
 
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
 // Certs is
 type Certs struct {
-	Keys []keys `json:"keys"`
+	Keys []Key `json:"keys"`
+	// parsedKeys are the keys parsed by kid, see parsedCerts
+	parsedKeys map[string]verifyingKey
 }
 
-type keys struct {
+// Key is a JSON Web Key (JWK) as published in Google certs
+type Key struct {
 	Kty string `json:"kty"`
 	Alg string `json:"alg"`
 	Use string `json:"use"`
 	Kid string `json:"kid"`
 	N   string `json:"n"`
 	E   string `json:"e"`
+	// Crv, X and Y are the curve and coordinates of EC keys
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+}
+
+// verifyingKey is a Key parsed once to be reused across verifications
+type verifyingKey interface {
+	// alg is the only signature algorithm the key verifies
+	alg() string
+	// verify verifies the signature of a token whose SHA256 sum is hashed
+	verify(hashed []byte, signature []byte) error
+	// source is the Key parsed
+	source() Key
+}
+
+// parseKey parses an EC or RSA key, keys without kty are RSA ones
+func parseKey(key Key) verifyingKey {
+	if key.Kty == "EC" {
+		return parseECKey(key)
+	}
+	return parseRSAKey(key)
+}
+
+// ErrInvalidCerts is the error of certs that cannot be decoded or that cannot verify tokens, see DecodeCerts
+var ErrInvalidCerts = errors.New("invalid certs")
+
+// DecodeCerts decodes JSON data with the Certs format, a JWKS, failing with ErrInvalidCerts unless
+// it has keys that can verify tokens: RSA keys with a kid, n and e, or EC keys with a kid, crv, x
+// and y. Keys of other types are kept, but a JWKS with only such keys is not valid. It catches the
+// responses that are not certs, such as error pages or truncated JSON served with 200 OK.
+func DecodeCerts(data []byte) (*Certs, error) {
+	var certs Certs
+	if err := json.Unmarshal(data, &certs); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidCerts, err)
+	}
+	if err := certs.validate(); err != nil {
+		return nil, err
+	}
+	return &certs, nil
+}
+
+// validate checks every RSA or EC key of the certs has the members needed to verify tokens
+func (c *Certs) validate() error {
+	usable := 0
+	for i, key := range c.Keys {
+		var members [][2]string
+		switch key.Kty {
+		case "RSA", "":
+			members = [][2]string{{"kid", key.Kid}, {"n", key.N}, {"e", key.E}}
+		case "EC":
+			members = [][2]string{{"kid", key.Kid}, {"crv", key.Crv}, {"x", key.X}, {"y", key.Y}}
+		default:
+			continue
+		}
+		for _, member := range members {
+			if member[1] == "" {
+				return fmt.Errorf("%w: key %d (kid %q) has no %s", ErrInvalidCerts, i, key.Kid, member[0])
+			}
+		}
+		usable++
+	}
+	if usable == 0 {
+		return fmt.Errorf("%w: there are no RSA nor EC keys among the %d keys", ErrInvalidCerts, len(c.Keys))
+	}
+	return nil
+}
+
+// parsedCerts returns a copy of certs whose keys are parsed once, meant to be done when certs
+// are loaded and before they are shared. Refreshed certs are new ones, so are their parsed keys.
+func parsedCerts(certs *Certs) *Certs {
+	if certs == nil {
+		return nil
+	}
+	parsed := &Certs{Keys: certs.Keys, parsedKeys: make(map[string]verifyingKey, len(certs.Keys))}
+	for _, key := range certs.Keys {
+		parsed.parsedKeys[key.Kid] = parseKey(key)
+	}
+	return parsed
+}
+
+// parsedKey returns key parsed, from the parsed keys of the certs unless they were not parsed
+func (c *Certs) parsedKey(key Key) verifyingKey {
+	if c != nil {
+		if parsed, ok := c.parsedKeys[key.Kid]; ok && parsed.source() == key {
+			return parsed
+		}
+	}
+	return parseKey(key)
+}
+
+// Equal tells if both certs hold the same set of keys, regardless of their order.
+// Keys are compared by kid and key material.
+func (c *Certs) Equal(other *Certs) bool {
+	if c == nil || other == nil {
+		return c == other
+	}
+	if len(c.Keys) != len(other.Keys) {
+		return false
+	}
+	mine, theirs := c.sortedKeys(), other.sortedKeys()
+	for i := range mine {
+		if mine[i].Kid != theirs[i].Kid || !mine[i].sameMaterial(theirs[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+func (c *Certs) sortedKeys() []Key {
+	sorted := make([]Key, len(c.Keys))
+	copy(sorted, c.Keys)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].sortKey() < sorted[j].sortKey()
+	})
+	return sorted
+}
+
+func (k Key) sortKey() string {
+	return strings.Join([]string{k.Kid, k.Kty, k.Alg, k.N, k.E, k.Crv, k.X, k.Y}, "\x00")
+}
+
+func (k Key) sameMaterial(other Key) bool {
+	return k.Kty == other.Kty && k.Alg == other.Alg && k.N == other.N && k.E == other.E &&
+		k.Crv == other.Crv && k.X == other.X && k.Y == other.Y
 }
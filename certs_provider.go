@@ -2,32 +2,241 @@ package GoogleIdTokenVerifier
 
 import (
 	"context"
-	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"io/fs"
 	"io/ioutil"
+	"log/slog"
+	"math/rand"
 	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 )
 
 const GoogleCertsURL string = "https://www.googleapis.com/oauth2/v3/certs"
 
+// FirebaseCertsURL publishes the keys signing Firebase Authentication ID tokens
+const FirebaseCertsURL string = "https://www.googleapis.com/service_accounts/v1/jwk/securetoken@system.gserviceaccount.com"
+
 type CertsProvider interface {
 	GetCerts() (*Certs, error)
+	// GetCertsContext is GetCerts bounded by ctx, which applies to any certs download it waits for
+	GetCertsContext(ctx context.Context) (*Certs, error)
 }
 
 type StaticCertsProvider struct {
 	certs *Certs
+	// mutex guards certs, which can be reloaded while tokens are verified
+	mutex sync.RWMutex
 }
 
 type CachedURLCertsProvider struct {
 	certs         *Certs
+	lastCerts     *Certs
 	url           string
 	expires       time.Time
+	fetchedAt     time.Time
 	refreshBefore time.Duration
-	mutex         sync.Mutex
-	updating      bool
-	updateMutex   sync.Mutex
+	fetchTimeout  time.Duration
+	staleGrace    time.Duration
+	synchronous   bool
+	fetcher       CertsFetcher
+	client        *http.Client
+	logger        Logger
+	tracer        Tracer
+	metrics       Metrics
+	// refreshJitter spreads refreshAt by a fraction of refreshBefore, see WithRefreshJitter
+	refreshJitter float64
+	// refreshAt is when the current certs start being refreshed in background
+	refreshAt time.Time
+	// offline forbids downloading the certs from url, see WithOffline
+	offline bool
+	// retryAttempts is the number of attempts to load the certs, see WithFetchRetries
+	retryAttempts  int
+	retryBaseDelay time.Duration
+	retryMaxDelay  time.Duration
+	slogger        *slog.Logger
+	mutex          sync.Mutex
+	// generation counts the certs stored, so a refresh is skipped when certs have changed meanwhile
+	generation  int
+	inflight    *certsFetch
+	updateMutex sync.Mutex
+	// etag and lastModified validate the last certs loaded in conditional requests
+	etag         string
+	lastModified string
+	// pollInterval is how often conditional requests are sent to detect key rotations
+	pollInterval time.Duration
+	// backgroundRefresh refreshes the certs before they expire without waiting for GetCerts calls
+	backgroundRefresh bool
+	// backgroundRetryDelay is the minimum time between background refreshes after a failure
+	backgroundRetryDelay time.Duration
+	closed               chan struct{}
+	closeOnce            sync.Once
+	// discovery resolves the URL of the certs when they are found with a discovery document
+	discovery *discovery
+	// unknownKeyRefreshAt is when the certs were last refreshed for a key they did not have, which is
+	// done at most every unknownKeyRefreshInterval
+	unknownKeyRefreshAt       time.Time
+	unknownKeyRefreshInterval time.Duration
+}
+
+// certsFetch is a fetch of certs in progress, shared by all the callers that need it
+type certsFetch struct {
+	done chan struct{}
+	err  error
+}
+
+// CertsProviderOption configures a CachedURLCertsProvider
+type CertsProviderOption func(*CachedURLCertsProvider)
+
+// WithSynchronousRefresh makes the provider never launch background goroutines.
+// Certs are not refreshed ahead of time but inline, in the GetCerts call that
+// finds them expired. This is the mode to use in FaaS environments, where
+// background goroutines can be frozen and resumed unpredictably.
+func WithSynchronousRefresh() CertsProviderOption {
+	return func(prv *CachedURLCertsProvider) {
+		prv.synchronous = true
+	}
+}
+
+// CertsFetcher retrieves the certs along with the time they expire
+type CertsFetcher func(ctx context.Context) (*Certs, time.Time, error)
+
+// WithCertsFetcher makes the provider get the certs from fetch instead of downloading
+// them from its URL. The provider still takes care of caching and refreshing them.
+func WithCertsFetcher(fetch CertsFetcher) CertsProviderOption {
+	return func(prv *CachedURLCertsProvider) {
+		prv.fetcher = fetch
+	}
+}
+
+// ErrOffline is the error of an offline provider asked to download certs, see WithOffline
+var ErrOffline = errors.New("certs provider is offline, certs are never downloaded")
+
+// WithOffline guarantees the provider never downloads the certs from its URL, for services
+// that must not make outbound requests. The certs must come from WithCertsFetcher, such as
+// certs loaded from a file, otherwise creating the provider fails instead of trying later.
+func WithOffline() CertsProviderOption {
+	return func(prv *CachedURLCertsProvider) {
+		prv.offline = true
+	}
+}
+
+// WithHTTPClient sets the client used to download the certs, to set up proxies, transports or
+// timeouts. By default a client with a timeout is used, never http.DefaultClient.
+func WithHTTPClient(client *http.Client) CertsProviderOption {
+	return func(prv *CachedURLCertsProvider) {
+		if client != nil {
+			prv.client = client
+		}
+	}
+}
+
+// WithCertsLogger sets the logger of the errors loading the certs, they are not logged by default
+func WithCertsLogger(logger Logger) CertsProviderOption {
+	return func(prv *CachedURLCertsProvider) {
+		if logger == nil {
+			logger = nopLogger{}
+		}
+		prv.logger = logger
+	}
+}
+
+// WithCertsSlogLogger sets the structured logger of the errors loading the certs, logged with
+// the url and, when there was a response, its status. A nil logger disables it, the default.
+func WithCertsSlogLogger(logger *slog.Logger) CertsProviderOption {
+	return func(prv *CachedURLCertsProvider) {
+		prv.slogger = logger
+	}
+}
+
+// WithCertsTracer traces GetCerts calls, telling if the certs were cached, and certs fetches,
+// with the URL and the HTTP status. They are children of the spans of the contexts given.
+func WithCertsTracer(tracer Tracer) CertsProviderOption {
+	return func(prv *CachedURLCertsProvider) {
+		prv.tracer = tracer
+	}
+}
+
+// WithCertsMetrics reports every refresh of the certs to metrics, see Metrics.OnCertRefresh
+func WithCertsMetrics(metrics Metrics) CertsProviderOption {
+	return func(prv *CachedURLCertsProvider) {
+		prv.metrics = metrics
+	}
+}
+
+// WithFetchRetries makes the provider try to load the certs up to attempts times, waiting an
+// exponential backoff with jitter between attempts, starting at baseDelay and capped at maxDelay.
+// Permanent failures, such as a malformed body or a client error status, are not retried,
+// neither once the context of the refresh is done. Each attempt is bounded by the fetch timeout.
+func WithFetchRetries(attempts int, baseDelay time.Duration, maxDelay time.Duration) CertsProviderOption {
+	return func(prv *CachedURLCertsProvider) {
+		prv.retryAttempts = attempts
+		prv.retryBaseDelay = baseDelay
+		prv.retryMaxDelay = maxDelay
+	}
+}
+
+// WithBackgroundRefresh makes the provider refresh the certs on its own when they enter the
+// refresh window (see WithRefreshBefore), so no GetCerts call has to wait for a download.
+// Refreshes on GetCerts calls remain as a fallback. The background refresh stops when the
+// provider is closed.
+func WithBackgroundRefresh() CertsProviderOption {
+	return func(prv *CachedURLCertsProvider) {
+		prv.backgroundRefresh = true
+	}
+}
+
+// WithRotationPollInterval makes the provider check every interval whether the certs have
+// changed, with cheap conditional requests (If-None-Match), so new keys are picked up
+// without waiting for the certs to expire. The polling stops when the provider is closed.
+func WithRotationPollInterval(interval time.Duration) CertsProviderOption {
+	return func(prv *CachedURLCertsProvider) {
+		prv.pollInterval = interval
+	}
+}
+
+// WithRefreshBefore sets when certs are refreshed in background, relative to their expiry.
+// It must be negative or zero: -time.Hour (the default) starts refreshing one hour before
+// the certs expire, while zero disables the background refresh.
+func WithRefreshBefore(d time.Duration) CertsProviderOption {
+	return func(prv *CachedURLCertsProvider) {
+		prv.refreshBefore = d
+	}
+}
+
+// WithRefreshJitter spreads the start of the background refresh by up to fraction of the
+// refresh window, either way, so a fleet of instances caching the same certs do not refresh
+// them at once. With the default window of one hour, 0.1 refreshes the certs between 54
+// and 66 minutes before they expire. It must be between 0 (the default) and 1. Expired certs
+// are still refreshed at once.
+func WithRefreshJitter(fraction float64) CertsProviderOption {
+	return func(prv *CachedURLCertsProvider) {
+		prv.refreshJitter = fraction
+	}
+}
+
+// WithCertsFetchTimeout sets the maximum time a single certs download can take.
+// It must be positive.
+func WithCertsFetchTimeout(d time.Duration) CertsProviderOption {
+	return func(prv *CachedURLCertsProvider) {
+		prv.fetchTimeout = d
+	}
+}
+
+// WithStaleGracePeriod keeps serving expired certs for up to d after their expiry
+// while they cannot be refreshed. It must not be negative, zero (the default)
+// disables serving stale certs.
+func WithStaleGracePeriod(d time.Duration) CertsProviderOption {
+	return func(prv *CachedURLCertsProvider) {
+		prv.staleGrace = d
+	}
 }
 
 func NewStaticCertsProvider() *StaticCertsProvider {
@@ -35,129 +244,761 @@ func NewStaticCertsProvider() *StaticCertsProvider {
 }
 
 func (prv *StaticCertsProvider) GetCerts() (*Certs, error) {
+	prv.mutex.RLock()
+	defer prv.mutex.RUnlock()
 	return prv.certs, nil
 }
 
-// AppendFromFile expects the path of a JSON file with the Certs format
+// GetCertsContext returns the certs loaded, it never waits
+func (prv *StaticCertsProvider) GetCertsContext(ctx context.Context) (*Certs, error) {
+	return prv.GetCerts()
+}
+
+// LoadFromFile expects the path of a JSON file with the Certs format
 func (prv *StaticCertsProvider) LoadFromFile(certpath string) error {
-	file, _ := ioutil.ReadFile(certpath)
-	data := Certs{}
-	err := json.Unmarshal([]byte(file), &data)
+	return prv.LoadFromFS(os.DirFS(filepath.Dir(certpath)), filepath.Base(certpath))
+}
+
+// LoadFromFS expects the name of a JSON file of fsys with the Certs format, such as a file of
+// an embed.FS
+func (prv *StaticCertsProvider) LoadFromFS(fsys fs.FS, name string) error {
+	file, err := fs.ReadFile(fsys, name)
 	if err != nil {
 		return err
 	}
-	prv.certs = &data
+	return prv.LoadFromBytes(file)
+}
+
+// LoadFromBytes expects JSON data with the Certs format, such as certs embedded with go:embed.
+// Certs can be reloaded at any time, such as on SIGHUP, even while tokens are being verified,
+// and they are kept unchanged if the new ones cannot be loaded.
+func (prv *StaticCertsProvider) LoadFromBytes(data []byte) error {
+	certs, err := DecodeCerts(data)
+	if err != nil {
+		return err
+	}
+	parsed := parsedCerts(certs)
+	prv.mutex.Lock()
+	prv.certs = parsed
+	prv.mutex.Unlock()
 	return nil
 }
 
+// LoadFromReader expects a reader of JSON data with the Certs format
+func (prv *StaticCertsProvider) LoadFromReader(r io.Reader) error {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	return prv.LoadFromBytes(data)
+}
+
 func NewCachedURLCertsProvider() *CachedURLCertsProvider {
 	return createDynamicCertProvider(GoogleCertsURL, defaultRefreshBefore)
 }
 
-func createDynamicCertProvider(rawUrl string, refreshBefore time.Duration) *CachedURLCertsProvider {
+// NewCachedURLCertsProviderWithClient returns a provider of Google certs downloading them with client
+func NewCachedURLCertsProviderWithClient(client *http.Client) *CachedURLCertsProvider {
+	return createDynamicCertProvider(GoogleCertsURL, defaultRefreshBefore, WithHTTPClient(client))
+}
+
+// NewCachedURLCertsProviderWithOptions returns a provider of Google certs configured with opts.
+// It fails if the options are not valid.
+func NewCachedURLCertsProviderWithOptions(opts ...CertsProviderOption) (*CachedURLCertsProvider, error) {
+	return newCachedURLCertsProvider(GoogleCertsURL, opts...)
+}
+
+// NewCachedURLCertsProviderWithURL returns a provider of the certs served at rawUrl instead of
+// Google's, such as a mirror or a mock endpoint, configured with opts. It fails if the URL is
+// not an absolute http(s) one or if the options are not valid.
+func NewCachedURLCertsProviderWithURL(rawUrl string, opts ...CertsProviderOption) (*CachedURLCertsProvider, error) {
+	if err := checkHTTPURL(rawUrl); err != nil {
+		return nil, fmt.Errorf("invalid certs URL %q: %v", rawUrl, err)
+	}
+	return newCachedURLCertsProvider(rawUrl, opts...)
+}
+
+// checkHTTPURL fails if rawUrl is not an absolute http(s) URL
+func checkHTTPURL(rawUrl string) error {
+	u, err := url.Parse(rawUrl)
+	if err != nil {
+		return err
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return errors.New("it must be an absolute http or https URL")
+	}
+	return nil
+}
+
+func newCachedURLCertsProvider(rawUrl string, opts ...CertsProviderOption) (*CachedURLCertsProvider, error) {
+	prv := newUnloadedCertsProvider(rawUrl, defaultRefreshBefore, opts...)
+	if err := prv.validate(); err != nil {
+		return nil, err
+	}
+	prv.start()
+	return prv, nil
+}
+
+func createDynamicCertProvider(rawUrl string, refreshBefore time.Duration, opts ...CertsProviderOption) *CachedURLCertsProvider {
+	prv := newUnloadedCertsProvider(rawUrl, refreshBefore, opts...)
+	prv.start()
+	return prv
+}
+
+func newUnloadedCertsProvider(rawUrl string, refreshBefore time.Duration, opts ...CertsProviderOption) *CachedURLCertsProvider {
 	prv := &CachedURLCertsProvider{
-		certs:         nil,
-		url:           rawUrl,
-		expires:       time.Now(),
-		refreshBefore: refreshBefore,
-		updating:      false}
+		certs:                     nil,
+		url:                       rawUrl,
+		expires:                   time.Now(),
+		refreshAt:                 time.Now(),
+		refreshBefore:             refreshBefore,
+		fetchTimeout:              defaultFetchTimeout,
+		client:                    defaultHTTPClient,
+		logger:                    nopLogger{},
+		retryAttempts:             1,
+		backgroundRetryDelay:      defaultBackgroundRetryDelay,
+		unknownKeyRefreshInterval: defaultUnknownKeyRefreshInterval,
+		closed:                    make(chan struct{})}
+	for _, opt := range opts {
+		opt(prv)
+	}
+	return prv
+}
 
+func (prv *CachedURLCertsProvider) start() {
 	// try to load certs right now in sync mode, even if it fails
 	_ = prv.updateCerts(context.Background())
-	return prv
+	if prv.pollInterval > 0 {
+		go prv.pollRotations()
+	}
+	if prv.backgroundRefresh {
+		go prv.refreshInBackground()
+	}
+}
+
+// refreshInBackground refreshes the certs when they enter their refresh window, until the provider is closed
+func (prv *CachedURLCertsProvider) refreshInBackground() {
+	failed := false
+	for {
+		wait := time.Until(prv.refreshTime())
+		// certs that could not be refreshed, or that are served already expired, are not
+		// refreshed again in a hot loop
+		_, expires, _ := prv.snapshot()
+		if (failed || !time.Now().Before(expires)) && wait < prv.backgroundRetryDelay {
+			wait = prv.backgroundRetryDelay
+		}
+		timer := time.NewTimer(wait)
+		select {
+		case <-prv.closed:
+			timer.Stop()
+			return
+		case <-timer.C:
+			failed = prv.updateCerts(context.Background()) != nil
+		}
+	}
 }
 
-const errFormatString string = "[GoogleTokenVerifier][%v] ERROR loading certs from %s: %v\n"
+// pollRotations refreshes the certs every poll interval until the provider is closed.
+// Refreshes are conditional requests, so they are cheap while the certs do not change.
+func (prv *CachedURLCertsProvider) pollRotations() {
+	ticker := time.NewTicker(prv.pollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-prv.closed:
+			return
+		case <-ticker.C:
+			_ = prv.updateCerts(context.Background())
+		}
+	}
+}
+
+// Close stops the background work of the provider, the rotation polling and the background
+// refresh. It is safe to call it several times.
+func (prv *CachedURLCertsProvider) Close() {
+	prv.closeOnce.Do(func() {
+		close(prv.closed)
+	})
+}
+
+func (prv *CachedURLCertsProvider) validate() error {
+	if prv.refreshBefore > 0 {
+		return fmt.Errorf("refreshBefore must be negative or zero, got %v", prv.refreshBefore)
+	}
+	if prv.refreshJitter < 0 || prv.refreshJitter > 1 {
+		return fmt.Errorf("refresh jitter must be between 0 and 1, got %v", prv.refreshJitter)
+	}
+	if prv.fetchTimeout <= 0 {
+		return fmt.Errorf("certs fetch timeout must be positive, got %v", prv.fetchTimeout)
+	}
+	if prv.retryAttempts < 1 {
+		return fmt.Errorf("certs fetch attempts must be at least 1, got %v", prv.retryAttempts)
+	}
+	if prv.retryAttempts > 1 && (prv.retryBaseDelay <= 0 || prv.retryMaxDelay < prv.retryBaseDelay) {
+		return fmt.Errorf("certs fetch retry delays must be positive and the max one not lower than the base one, got %v and %v", prv.retryBaseDelay, prv.retryMaxDelay)
+	}
+	if prv.pollInterval < 0 {
+		return fmt.Errorf("rotation poll interval must not be negative, got %v", prv.pollInterval)
+	}
+	if prv.staleGrace < 0 {
+		return fmt.Errorf("stale grace period must not be negative, got %v", prv.staleGrace)
+	}
+	if prv.offline && prv.fetcher == nil {
+		return fmt.Errorf("%w: a certs fetcher is required", ErrOffline)
+	}
+	if prv.discovery != nil && (prv.offline || prv.fetcher != nil) {
+		return errors.New("certs found with a discovery document are downloaded, without certs fetcher nor offline mode")
+	}
+	return nil
+}
+
+const errFormatString string = "[GoogleTokenVerifier] ERROR loading certs from %s: %v"
 const errCouldNotLoad string = "Could not retrieve a valid certificate from %s\n"
 const defaultRefreshBefore time.Duration = -time.Hour
+const defaultFetchTimeout time.Duration = 10 * time.Second
+
+// defaultBackgroundRetryDelay is the minimum time between background refreshes after a failure
+const defaultBackgroundRetryDelay time.Duration = 10 * time.Second
+
+// defaultUnknownKeyRefreshInterval is the minimum time between refreshes for unknown keys, so that
+// tokens with bogus kids cannot make the provider hammer the certs URL
+const defaultUnknownKeyRefreshInterval time.Duration = 30 * time.Second
+
+// defaultCertsMaxAge is how long certs are kept when the response tells nothing about it
+const defaultCertsMaxAge time.Duration = 2 * time.Hour
+
+// defaultHTTPClient downloads the certs unless WithHTTPClient is used. Unlike
+// http.DefaultClient it has a timeout, so a hung connection cannot block refreshes.
+var defaultHTTPClient = &http.Client{Timeout: 30 * time.Second}
 
 func (prv *CachedURLCertsProvider) GetCerts() (*Certs, error) {
-	dNow := time.Now()
+	return prv.GetCertsContext(context.Background())
+}
 
-	prv.mutex.Lock()
-	defer prv.mutex.Unlock()
+// GetCertsContext returns the certs like GetCerts does. When the certs have to be refreshed
+// synchronously, the download is bounded by ctx.
+func (prv *CachedURLCertsProvider) GetCertsContext(ctx context.Context) (*Certs, error) {
+	ctx, span := startSpan(prv.tracer, ctx, "GoogleIdTokenVerifier.GetCerts")
+	certs, cached, err := prv.getCerts(ctx)
+	span.SetAttributes(slog.Bool("certs.cached", cached))
+	endSpan(span, err)
+	return certs, err
+}
 
-	if dNow.After(prv.expires.Add(prv.refreshBefore)) {
-		if dNow.After(prv.expires) {
-			// sync
-			prv.certs = nil
-			prv.mutex.Unlock()
-			err := prv.updateCerts(context.Background())
-			prv.mutex.Lock()
-			return prv.certs, err
+// getCerts returns the certs, telling if they were cached or had to be fetched
+func (prv *CachedURLCertsProvider) getCerts(ctx context.Context) (*Certs, bool, error) {
+	now := time.Now()
+	certs, expires, generation := prv.snapshot()
+
+	if now.After(expires) {
+		// sync, the refresh is shared with the other callers needing it
+		err := prv.refreshCerts(ctx, generation)
+		certs, expires, refreshed := prv.snapshot()
+		// without newer certs, the stale ones are kept only within the grace period
+		if refreshed == generation && !now.Before(expires.Add(prv.staleGrace)) {
+			certs = nil
 		}
+		if certs == nil {
+			if err != nil {
+				return nil, false, err
+			}
+			return nil, false, fmt.Errorf(errCouldNotLoad, prv.certsURL())
+		}
+		return certs, false, nil
+	}
+
+	if now.After(prv.refreshTime()) && !prv.synchronous {
 		go func() {
-			_ = prv.updateCerts(context.Background())
+			_ = prv.refreshCerts(context.Background(), generation)
 		}()
 	}
+	if certs == nil {
+		return nil, true, fmt.Errorf(errCouldNotLoad, prv.certsURL())
+	}
+	return certs, true, nil
+}
 
-	if prv.certs == nil {
-		return nil, fmt.Errorf(errCouldNotLoad, prv.url)
+// snapshot returns the current certs along with when they expire and their generation
+func (prv *CachedURLCertsProvider) snapshot() (*Certs, time.Time, int) {
+	prv.mutex.Lock()
+	defer prv.mutex.Unlock()
+	return prv.certs, prv.expires, prv.generation
+}
+
+// logErr logs a failure loading the certs, attrs are additional key-value pairs for the slog logger
+func (prv *CachedURLCertsProvider) logErr(err error, attrs ...any) {
+	certsURL := prv.certsURL()
+	prv.logger.Errorf(errFormatString, certsURL, err)
+	if prv.slogger != nil {
+		if prv.discovery != nil {
+			attrs = append(attrs, "discovery_url", prv.discovery.url)
+		}
+		prv.slogger.Error("loading certs failed", append([]any{"url", certsURL, "error", err}, attrs...)...)
 	}
-	return prv.certs, nil
 }
 
-func (prv *CachedURLCertsProvider) logErr(err error) {
-	fmt.Printf(errFormatString, time.Now().Format(time.RFC3339), prv.url, err)
+// certsURL returns the URL the certs are downloaded from, which for providers using discovery is
+// the last jwks_uri resolved, or the URL of the discovery document until there is one
+func (prv *CachedURLCertsProvider) certsURL() string {
+	if prv.discovery != nil {
+		if jwksURI := prv.discovery.lastJwksURI(); jwksURI != "" {
+			return jwksURI
+		}
+	}
+	return prv.url
 }
 
 func (prv *CachedURLCertsProvider) updateCerts(ctx context.Context) error {
+	prv.mutex.Lock()
+	generation := prv.generation
+	prv.mutex.Unlock()
+	return prv.refreshCerts(ctx, generation)
+}
+
+// refreshCerts fetches the certs unless they have been stored again since generation.
+// Concurrent callers wait for the fetch in progress and share its result, each of them
+// giving up when its own ctx is done.
+func (prv *CachedURLCertsProvider) refreshCerts(ctx context.Context, generation int) error {
+	// nobody is waiting for the certs anymore
+	if err := ctx.Err(); err != nil {
+		return err
+	}
 	prv.updateMutex.Lock()
-	if prv.updating {
-		prv.updateMutex.Unlock()
-		return nil
+	call := prv.inflight
+	if call == nil {
+		prv.mutex.Lock()
+		refreshed := prv.generation != generation
+		prv.mutex.Unlock()
+		if refreshed {
+			prv.updateMutex.Unlock()
+			return nil
+		}
+		call = &certsFetch{done: make(chan struct{})}
+		prv.inflight = call
+		if prv.synchronous {
+			// no background goroutine, the caller loads the certs itself within its ctx
+			prv.updateMutex.Unlock()
+			prv.fetchShared(ctx, call, generation)
+			return call.err
+		}
+		go prv.fetchShared(context.WithoutCancel(ctx), call, generation)
 	}
-	prv.updating = true
 	prv.updateMutex.Unlock()
-	err := prv.loadCertsFromURL(ctx)
+
+	select {
+	case <-call.done:
+		return call.err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// fetchShared loads the certs for the callers waiting for call. Unless the refresh is
+// synchronous, it runs on a context detached from the one of the caller starting it, so that
+// this caller giving up does not fail the others, and bounded by fetchBudget instead.
+func (prv *CachedURLCertsProvider) fetchShared(ctx context.Context, call *certsFetch, generation int) {
+	ctx, cancel := context.WithTimeout(ctx, prv.fetchBudget())
+	defer cancel()
+	start := time.Now()
+	call.err = prv.loadCerts(ctx)
+	if prv.metrics != nil {
+		// certs that did not change are revalidated without being stored again
+		prv.mutex.Lock()
+		fromCache := call.err == nil && prv.generation == generation
+		prv.mutex.Unlock()
+		prv.metrics.OnCertRefresh(call.err == nil, time.Since(start), fromCache)
+	}
+
 	prv.updateMutex.Lock()
-	prv.updating = false
+	prv.inflight = nil
 	prv.updateMutex.Unlock()
-	return err
+	close(call.done)
+}
+
+// fetchBudget is the longest loading the certs can take: every attempt timing out, with the
+// longest delay between them
+func (prv *CachedURLCertsProvider) fetchBudget() time.Duration {
+	return time.Duration(prv.retryAttempts)*prv.fetchTimeout + time.Duration(prv.retryAttempts-1)*prv.retryMaxDelay
+}
+
+// loadCerts loads the certs, retrying failed attempts with an exponential backoff as long as
+// the failures are not permanent ones, ctx is not done and the provider is not closed
+func (prv *CachedURLCertsProvider) loadCerts(ctx context.Context) error {
+	for attempt := 1; ; attempt++ {
+		err := prv.loadCertsOnce(ctx)
+		var permanent *permanentError
+		if err == nil || errors.As(err, &permanent) || attempt >= prv.retryAttempts {
+			return err
+		}
+		timer := time.NewTimer(prv.retryDelay(attempt))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		case <-prv.closed:
+			timer.Stop()
+			return err
+		case <-timer.C:
+		}
+	}
 }
 
-func (prv *CachedURLCertsProvider) loadCertsFromURL(ctx context.Context) error {
-	req, err := http.NewRequestWithContext(ctx, "GET", prv.url, nil)
+// retryDelay returns the delay before retrying after the failed attempt, the exponential
+// backoff with jitter: a random duration between half and all of the backoff
+func (prv *CachedURLCertsProvider) retryDelay(attempt int) time.Duration {
+	// doubled one attempt at a time, so that it cannot overflow before being capped
+	backoff := prv.retryBaseDelay
+	for i := 1; i < attempt && backoff < prv.retryMaxDelay; i++ {
+		backoff *= 2
+	}
+	if backoff > prv.retryMaxDelay {
+		backoff = prv.retryMaxDelay
+	}
+	return backoff/2 + time.Duration(rand.Int63n(int64(backoff/2)+1))
+}
+
+// maxCertsErrorBodyBytes is the most of the body of a failed certs response kept in a CertsFetchError
+const maxCertsErrorBodyBytes = 1024
+
+// maxCertsBodyBytes is the largest certs response read, way more than the few KB of a JWKS, so that
+// a misbehaving endpoint cannot exhaust the memory
+const maxCertsBodyBytes = 1 << 20
+
+// ErrCertsResponseTooLarge is the error of a certs response larger than 1 MiB
+var ErrCertsResponseTooLarge = errors.New("certs response is too large")
+
+// readCertsBody reads body, failing with ErrCertsResponseTooLarge if it exceeds maxCertsBodyBytes
+func readCertsBody(body io.Reader) ([]byte, error) {
+	data, err := ioutil.ReadAll(io.LimitReader(body, maxCertsBodyBytes+1))
 	if err != nil {
-		prv.logErr(err)
-		return err
+		return nil, err
+	}
+	if len(data) > maxCertsBodyBytes {
+		return nil, fmt.Errorf("%w: more than %d bytes", ErrCertsResponseTooLarge, maxCertsBodyBytes)
+	}
+	return data, nil
+}
+
+// CertsFetchError is a certs response with an unsuccessful status code. Body is the start of
+// the response body, at most 1KB, so the status and any hint of the server can be inspected
+// with errors.As.
+type CertsFetchError struct {
+	StatusCode int
+	Body       string
+	URL        string
+}
+
+func (e *CertsFetchError) Error() string {
+	if e.Body == "" {
+		return fmt.Sprintf("Unsuccessful status code: %v", e.StatusCode)
+	}
+	return fmt.Sprintf("Unsuccessful status code: %v, body: %q", e.StatusCode, e.Body)
+}
+
+// permanentError is a failure loading the certs that retrying would not fix
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string {
+	return e.err.Error()
+}
+
+func (e *permanentError) Unwrap() error {
+	return e.err
+}
+
+func (prv *CachedURLCertsProvider) loadCertsOnce(ctx context.Context) (err error) {
+	ctx, span := startSpan(prv.tracer, ctx, "GoogleIdTokenVerifier.FetchCerts")
+	defer func() { endSpan(span, err) }()
+	ctx, cancel := context.WithTimeout(ctx, prv.fetchTimeout)
+	defer cancel()
+	if prv.fetcher == nil && prv.offline {
+		prv.logErr(ErrOffline)
+		return &permanentError{ErrOffline}
+	}
+	if prv.fetcher == nil {
+		return prv.loadCertsFromURL(ctx, span)
+	}
+
+	certs, expires, err := prv.fetcher(ctx)
+	if err == nil && certs == nil {
+		err = errors.New("certs fetcher returned no certs")
 	}
-	res, err := http.DefaultClient.Do(req)
 	if err != nil {
 		prv.logErr(err)
 		return err
 	}
+	if err := certs.validate(); err != nil {
+		prv.logErr(err)
+		return &permanentError{err}
+	}
+	prv.storeCerts(certs, expires)
+	return nil
+}
 
-	if res.StatusCode < 200 || res.StatusCode >= 300 {
-		err := fmt.Errorf("Unsuccessful status code: %v", res.StatusCode)
+func (prv *CachedURLCertsProvider) loadCertsFromURL(ctx context.Context, span Span) error {
+	certsURL := prv.url
+	if prv.discovery != nil {
+		jwksURI, changed, err := prv.discovery.resolve(ctx, prv.client)
+		if err != nil {
+			prv.logErr(err)
+		}
+		if jwksURI == "" {
+			return err
+		}
+		certsURL = jwksURI
+		if changed {
+			// the validators of the certs of the previous URL do not apply
+			prv.mutex.Lock()
+			prv.etag, prv.lastModified = "", ""
+			prv.mutex.Unlock()
+		}
+	}
+	span.SetAttributes(slog.String("http.url", certsURL))
+
+	req, err := http.NewRequestWithContext(ctx, "GET", certsURL, nil)
+	if err != nil {
 		prv.logErr(err)
 		return err
 	}
-
-	expiresHeader, err := http.ParseTime(res.Header.Get("Expires"))
+	prv.mutex.Lock()
+	etag, lastModified := prv.etag, prv.lastModified
+	prv.mutex.Unlock()
+	if etag != "" {
+		req.Header.Set("If-None-Match", etag)
+	}
+	if lastModified != "" {
+		req.Header.Set("If-Modified-Since", lastModified)
+	}
+	res, err := prv.client.Do(req)
 	if err != nil {
 		prv.logErr(err)
 		return err
 	}
+	defer res.Body.Close()
+	span.SetAttributes(slog.Int("http.status_code", res.StatusCode))
+
+	if res.StatusCode == http.StatusNotModified {
+		return prv.revalidateCerts(res.Header)
+	}
+
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		body, _ := ioutil.ReadAll(io.LimitReader(res.Body, maxCertsErrorBodyBytes))
+		var err error = &CertsFetchError{StatusCode: res.StatusCode, Body: string(body), URL: certsURL}
+		if res.StatusCode >= 400 && res.StatusCode < 500 && res.StatusCode != http.StatusRequestTimeout && res.StatusCode != http.StatusTooManyRequests {
+			err = &permanentError{err}
+		}
+		prv.logErr(err, "status", res.StatusCode)
+		return err
+	}
 
-	bCerts, err := ioutil.ReadAll(res.Body)
+	bCerts, err := readCertsBody(res.Body)
 	if err != nil {
-		prv.logErr(err)
+		prv.logErr(err, "status", res.StatusCode)
 		return err
 	}
-	res.Body.Close()
 
-	var certs *Certs
-	err = json.Unmarshal(bCerts, &certs)
+	certs, err := DecodeCerts(bCerts)
 	if err != nil {
+		prv.logErr(err, "status", res.StatusCode)
+		return &permanentError{err}
+	}
+
+	prv.storeCerts(certs, certsExpiry(res.Header, time.Now()))
+	prv.mutex.Lock()
+	prv.etag = res.Header.Get("ETag")
+	prv.lastModified = res.Header.Get("Last-Modified")
+	prv.mutex.Unlock()
+	return nil
+}
+
+// revalidateCerts keeps the last certs loaded when the server tells they have not changed
+func (prv *CachedURLCertsProvider) revalidateCerts(header http.Header) error {
+	prv.mutex.Lock()
+	defer prv.mutex.Unlock()
+	if prv.lastCerts == nil {
+		err := errors.New("Not modified response without any certs loaded")
 		prv.logErr(err)
 		return err
 	}
+	prv.certs = prv.lastCerts
+	// the certs are confirmed to be the current ones, as if they had been fetched again
+	prv.fetchedAt = time.Now()
+	prv.setExpiry(certsExpiry(header, prv.fetchedAt))
+	if etag := header.Get("ETag"); etag != "" {
+		prv.etag = etag
+	}
+	if lastModified := header.Get("Last-Modified"); lastModified != "" {
+		prv.lastModified = lastModified
+	}
+	return nil
+}
+
+// certsExpiry returns when certs received at now with header expire. Cache-Control is preferred
+// to Expires, and certs without any of them expire after defaultCertsMaxAge.
+func certsExpiry(header http.Header, now time.Time) time.Time {
+	for _, directive := range strings.Split(header.Get("Cache-Control"), ",") {
+		directive = strings.ToLower(strings.TrimSpace(directive))
+		if directive == "no-cache" || directive == "no-store" {
+			return now
+		}
+	}
+	for _, directive := range strings.Split(header.Get("Cache-Control"), ",") {
+		name, value, found := strings.Cut(strings.TrimSpace(directive), "=")
+		if !found || !strings.EqualFold(name, "max-age") {
+			continue
+		}
+		maxAge, err := strconv.ParseInt(strings.Trim(value, `"`), 10, 64)
+		if err != nil || maxAge < 0 {
+			continue
+		}
+		// Age is the time the response spent in caches before reaching us
+		age, err := strconv.ParseInt(header.Get("Age"), 10, 64)
+		if err != nil || age < 0 {
+			age = 0
+		}
+		return now.Add(time.Duration(maxAge-age) * time.Second)
+	}
+	if expires, err := http.ParseTime(header.Get("Expires")); err == nil {
+		return expires
+	}
+	return now.Add(defaultCertsMaxAge)
+}
+
+// setExpiry sets when the current certs expire and when they start being refreshed, with the
+// jitter drawn once for them. The mutex must be held.
+func (prv *CachedURLCertsProvider) setExpiry(expires time.Time) {
+	prv.expires = expires
+	jitter := time.Duration(float64(prv.refreshBefore) * prv.refreshJitter * (2*rand.Float64() - 1))
+	prv.refreshAt = expires.Add(prv.refreshBefore + jitter)
+	// once refreshed, certs living less than the refresh window are refreshed again halfway through
+	// their lifetime, instead of as soon as they are stored, over and over. The first certs loaded
+	// are refreshed right away, as they may have been served late in their lifetime by a cache.
+	now := time.Now()
+	if halfway := now.Add(expires.Sub(now) / 2); prv.generation > 0 && expires.After(now) && prv.refreshAt.Before(halfway) {
+		prv.refreshAt = halfway
+	}
+}
+
+// refreshTime returns when the current certs start being refreshed in background
+func (prv *CachedURLCertsProvider) refreshTime() time.Time {
+	prv.mutex.Lock()
+	defer prv.mutex.Unlock()
+	return prv.refreshAt
+}
 
+func (prv *CachedURLCertsProvider) storeCerts(certs *Certs, expires time.Time) {
 	prv.mutex.Lock()
 	defer prv.mutex.Unlock()
 
-	prv.expires = expiresHeader
+	certs = parsedCerts(certs)
+	prv.setExpiry(expires)
 	prv.certs = certs
+	prv.lastCerts = certs
+	prv.fetchedAt = time.Now()
+	prv.generation++
+}
+
+// refreshForUnknownKey refreshes certs, which do not have the key of a token, in case the key has
+// just been rotated in. It returns the newer certs, or nil when there are none, such as when the
+// certs have already been refreshed for an unknown key within the last unknownKeyRefreshInterval.
+// A refresh given up because ctx is done does not count.
+func (prv *CachedURLCertsProvider) refreshForUnknownKey(ctx context.Context, certs *Certs) *Certs {
+	prv.mutex.Lock()
+	current, generation := prv.certs, prv.generation
+	if current != nil && current != certs {
+		// the certs have been refreshed meanwhile
+		prv.mutex.Unlock()
+		return current
+	}
+	if time.Since(prv.unknownKeyRefreshAt) < prv.unknownKeyRefreshInterval {
+		prv.mutex.Unlock()
+		return nil
+	}
+	prv.mutex.Unlock()
+
+	err := prv.refreshCerts(ctx, generation)
+	if ctx.Err() == nil {
+		prv.mutex.Lock()
+		prv.unknownKeyRefreshAt = time.Now()
+		prv.mutex.Unlock()
+	}
+	if err != nil {
+		return nil
+	}
+	if refreshed, _, _ := prv.snapshot(); refreshed != certs {
+		return refreshed
+	}
 	return nil
 }
+
+func (prv *CachedURLCertsProvider) certsFetchedAt() time.Time {
+	prv.mutex.Lock()
+	defer prv.mutex.Unlock()
+	return prv.fetchedAt
+}
+
+// CertsAge returns how long ago the certs currently served were fetched, or revalidated by
+// a not modified response, or zero if no certs have been fetched yet
+func (prv *CachedURLCertsProvider) CertsAge() time.Duration {
+	prv.mutex.Lock()
+	defer prv.mutex.Unlock()
+	if prv.fetchedAt.IsZero() {
+		return 0
+	}
+	return time.Since(prv.fetchedAt)
+}
+
+// servingStaleCerts tells if the certs are expired and could not be refreshed, the provider is degraded
+func (prv *CachedURLCertsProvider) servingStaleCerts() bool {
+	prv.mutex.Lock()
+	defer prv.mutex.Unlock()
+	now := time.Now()
+	return prv.certs != nil && now.After(prv.expires) && now.Before(prv.expires.Add(prv.staleGrace))
+}
+
+// CertsSnapshot is the state of the certs of a CachedURLCertsProvider at a given time
+type CertsSnapshot struct {
+	// Certs are the certs served, nil if none could be loaded. They must not be modified.
+	Certs *Certs
+	// Expiry is when the certs expire, they are refreshed before according to WithRefreshBefore
+	Expiry time.Time
+	// KeyIDs are the kids of the certs, in the order of the certs
+	KeyIDs []string
+}
+
+// Snapshot returns the certs served along with their expiry and key IDs, all consistent with
+// each other even while the certs are being refreshed
+func (prv *CachedURLCertsProvider) Snapshot() CertsSnapshot {
+	certs, expires, _ := prv.snapshot()
+	snapshot := CertsSnapshot{Certs: certs, Expiry: expires}
+	if certs != nil {
+		snapshot.KeyIDs = make([]string, len(certs.Keys))
+		for i, key := range certs.Keys {
+			snapshot.KeyIDs[i] = key.Kid
+		}
+	}
+	return snapshot
+}
+
+// Expiry returns when the certs served expire
+func (prv *CachedURLCertsProvider) Expiry() time.Time {
+	return prv.Snapshot().Expiry
+}
+
+// KeyIDs returns the kids of the certs served, which are the keys trusted
+func (prv *CachedURLCertsProvider) KeyIDs() []string {
+	return prv.Snapshot().KeyIDs
+}
+
+// lastLoadedCerts returns the last certs successfully loaded, even if they have expired as long
+// as they are within the stale grace period, nil otherwise
+func (prv *CachedURLCertsProvider) lastLoadedCerts() *Certs {
+	prv.mutex.Lock()
+	defer prv.mutex.Unlock()
+	if !time.Now().Before(prv.expires.Add(prv.staleGrace)) {
+		return nil
+	}
+	return prv.lastCerts
+}
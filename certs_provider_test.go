@@ -1,13 +1,25 @@
 package GoogleIdTokenVerifier
 
 import (
+	"bytes"
+	"context"
+	"embed"
+	"encoding/json"
+	"errors"
 	"io"
+	"io/fs"
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"testing"
+	"testing/fstest"
+	"testing/iotest"
 	"time"
 
 	"github.com/stretchr/testify/assert"
@@ -25,6 +37,106 @@ func TestStaticCerts(t *testing.T) {
 	assertCertsCorrect(t, certs)
 	err = staticProvider.LoadFromFile("testdata/non-existing.json")
 	require.Error(t, err)
+	assert.True(t, errors.Is(err, os.ErrNotExist), "got %v", err)
+}
+
+//go:embed testdata/certs.json
+var testCertsFS embed.FS
+
+func TestStaticCertsFromFS(t *testing.T) {
+	staticProvider := NewStaticCertsProvider()
+	require.NoError(t, staticProvider.LoadFromFS(testCertsFS, testCertsPath))
+	certs, err := staticProvider.GetCerts()
+	require.NoError(t, err)
+	assertCertsCorrect(t, certs)
+
+	err = staticProvider.LoadFromFS(testCertsFS, "testdata/non-existing.json")
+	assert.True(t, errors.Is(err, fs.ErrNotExist), "got %v", err)
+	assert.Error(t, staticProvider.LoadFromFS(fstest.MapFS{"certs.json": {Data: []byte("not json")}}, "certs.json"))
+
+	// absolute paths are loaded from the OS filesystem
+	absPath, err := filepath.Abs(testCertsPath)
+	require.NoError(t, err)
+	staticProvider = NewStaticCertsProvider()
+	require.NoError(t, staticProvider.LoadFromFile(absPath))
+	certs, err = staticProvider.GetCerts()
+	require.NoError(t, err)
+	assertCertsCorrect(t, certs)
+}
+
+func TestStaticCertsFromBytesAndReader(t *testing.T) {
+	data, err := ioutil.ReadFile(testCertsPath)
+	require.NoError(t, err)
+
+	staticProvider := NewStaticCertsProvider()
+	require.NoError(t, staticProvider.LoadFromBytes(data))
+	certs, err := staticProvider.GetCerts()
+	require.NoError(t, err)
+	assertCertsCorrect(t, certs)
+
+	staticProvider = NewStaticCertsProvider()
+	require.NoError(t, staticProvider.LoadFromReader(bytes.NewReader(data)))
+	certs, err = staticProvider.GetCerts()
+	require.NoError(t, err)
+	assertCertsCorrect(t, certs)
+
+	assert.Error(t, staticProvider.LoadFromBytes([]byte("not json")))
+	assert.Error(t, staticProvider.LoadFromReader(iotest.ErrReader(errors.New("read failure"))))
+	// certs are kept when loading fails
+	certs, err = staticProvider.GetCerts()
+	require.NoError(t, err)
+	assertCertsCorrect(t, certs)
+}
+
+func TestStaticCertsReload(t *testing.T) {
+	certs := loadTestCerts(t)
+	data, err := json.Marshal(certs)
+	require.NoError(t, err)
+	// the rotated certs only keep the key signing the test tokens
+	signingKey, err := choiceKeyByKeyID(certs.Keys, testKeyID)
+	require.NoError(t, err)
+	rotated, err := json.Marshal(&Certs{Keys: []Key{signingKey}})
+	require.NoError(t, err)
+
+	staticProvider := NewStaticCertsProvider()
+	require.NoError(t, staticProvider.LoadFromBytes(data))
+	verifier := New(staticProvider)
+	authToken := signTestToken(t, testClaims(time.Now()))
+
+	// certs are reloaded while tokens are verified, the race detector catches unguarded accesses
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				certs, err := staticProvider.GetCerts()
+				assert.NoError(t, err)
+				assert.NotEmpty(t, certs.Keys)
+				_, err = verifier.VerifyE(authToken, testAud)
+				assert.NoError(t, err)
+			}
+		}()
+	}
+	for i := 0; i < 200; i++ {
+		if i%2 == 0 {
+			require.NoError(t, staticProvider.LoadFromBytes(rotated))
+		} else {
+			require.NoError(t, staticProvider.LoadFromReader(bytes.NewReader(data)))
+		}
+	}
+	close(done)
+	wg.Wait()
+
+	reloaded, err := staticProvider.GetCerts()
+	require.NoError(t, err)
+	assert.Equal(t, certs.Keys, reloaded.Keys)
 }
 
 func TestHappyDynamicCerts(t *testing.T) {
@@ -101,7 +213,13 @@ func TestReduceDynamicCerts(t *testing.T) {
 		}(certProv)
 	}
 	wg.Wait()
-	// This is to avoid data races warnings even if the subroutines have already finished
+	// the refresh is started in background, so it can reach the server after the callers have
+	// returned. This is to avoid data races warnings even if the subroutines have already finished
+	assert.Eventually(t, func() bool {
+		return atomic.LoadInt32(&numRequests) == int32(2)
+	}, 5*sleepTime, 10*time.Millisecond)
+	// and no other refresh follows while it is in flight
+	time.Sleep(2 * sleepTime)
 	nRequests := atomic.LoadInt32(&numRequests)
 	assert.Equal(t, int32(2), nRequests)
 	ts.Close()
@@ -167,3 +285,954 @@ func getSlowHandlerFunc(statusCode int, expiresIn time.Duration, requestCount *i
 		getHandlerFunc(statusCode, expiresIn, requestCount)(w, r)
 	}
 }
+
+func TestSynchronousRefresh(t *testing.T) {
+	var numRequests int32 = 0
+	// certs expire in 10 minutes, which is inside the default refresh window
+	ts := httptest.NewServer(getTestCertsHandlerFunc(t, 10*time.Minute, &numRequests))
+	defer ts.Close()
+
+	verifier := New(createDynamicCertProvider(ts.URL, defaultRefreshBefore, WithSynchronousRefresh()))
+	authToken := signTestToken(t, testClaims(time.Now()))
+	require.NotNil(t, verifier.Verify(authToken, testAud))
+
+	goroutines := runtime.NumGoroutine()
+	for i := 0; i < 100; i++ {
+		require.NotNil(t, verifier.Verify(authToken, testAud))
+	}
+	assert.LessOrEqual(t, runtime.NumGoroutine(), goroutines)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&numRequests))
+}
+
+func TestSynchronousRefreshWhenExpired(t *testing.T) {
+	var numRequests int32 = 0
+	var fetchGoroutines int32
+	goroutines := providerGoroutines()
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// the goroutines the provider has launched while it is fetching the certs
+		atomic.AddInt32(&fetchGoroutines, int32(providerGoroutines()-goroutines))
+		getHandlerFunc(http.StatusOK, -time.Minute, &numRequests)(w, r)
+	}))
+	defer ts.Close()
+
+	certProv := createDynamicCertProvider(ts.URL, defaultRefreshBefore, WithSynchronousRefresh())
+	assert.Equal(t, int32(1), atomic.LoadInt32(&numRequests))
+	for i := 2; i <= 5; i++ {
+		certs, err := certProv.GetCerts()
+		require.NoError(t, err)
+		assertCertsCorrect(t, certs)
+		// the refresh has already happened when GetCerts returns
+		assert.Equal(t, int32(i), atomic.LoadInt32(&numRequests))
+	}
+	// the expired certs were refreshed without launching any goroutine
+	assert.Zero(t, atomic.LoadInt32(&fetchGoroutines))
+}
+
+// providerGoroutines counts the running goroutines launched by this package
+func providerGoroutines() int {
+	buf := make([]byte, 1<<20)
+	buf = buf[:runtime.Stack(buf, true)]
+	return strings.Count(string(buf), "created by github.com/osangenis/googleIdTokenVerifier.")
+}
+
+func TestCertsProviderOptions(t *testing.T) {
+	ts := httptest.NewServer(getHandlerFunc(http.StatusOK, time.Hour*2, nil))
+	defer ts.Close()
+
+	tests := []struct {
+		testName   string
+		opts       []CertsProviderOption
+		expSuccess bool
+	}{
+		{"No options", nil, true},
+		{"All options valid", []CertsProviderOption{
+			WithRefreshBefore(-10 * time.Minute),
+			WithCertsFetchTimeout(2 * time.Second),
+			WithStaleGracePeriod(time.Hour),
+		}, true},
+		{"Background refresh disabled", []CertsProviderOption{WithRefreshBefore(0)}, true},
+		{"Positive refreshBefore", []CertsProviderOption{WithRefreshBefore(time.Minute)}, false},
+		{"Zero fetch timeout", []CertsProviderOption{WithCertsFetchTimeout(0)}, false},
+		{"Negative fetch timeout", []CertsProviderOption{WithCertsFetchTimeout(-time.Second)}, false},
+		{"Negative stale grace period", []CertsProviderOption{WithStaleGracePeriod(-time.Minute)}, false},
+		{"Valid and invalid options", []CertsProviderOption{
+			WithCertsFetchTimeout(2 * time.Second),
+			WithRefreshBefore(time.Hour),
+		}, false},
+	}
+
+	for _, tc := range tests {
+		// nolint
+		t.Run(tc.testName, func(t *testing.T) {
+			certProv, err := newCachedURLCertsProvider(ts.URL, tc.opts...)
+			if tc.expSuccess {
+				require.NoError(t, err)
+				certs, err := certProv.GetCerts()
+				assert.NoError(t, err)
+				assertCertsCorrect(t, certs)
+			} else {
+				assert.Error(t, err)
+				assert.Nil(t, certProv)
+			}
+		})
+	}
+}
+
+func TestCertsFetchTimeout(t *testing.T) {
+	ts := httptest.NewServer(getSlowHandlerFunc(http.StatusOK, time.Hour*2, nil, 500*time.Millisecond))
+	defer ts.Close()
+
+	certProv, err := newCachedURLCertsProvider(ts.URL, WithCertsFetchTimeout(50*time.Millisecond))
+	require.NoError(t, err)
+	certs, err := certProv.GetCerts()
+	assert.Error(t, err)
+	assert.Nil(t, certs)
+}
+
+func TestStaleGracePeriod(t *testing.T) {
+	tests := []struct {
+		testName   string
+		grace      time.Duration
+		expSuccess bool
+	}{
+		{"Expired certs within the grace period", time.Hour, true},
+		{"Expired certs beyond the grace period", 30 * time.Second, false},
+		{"No grace period", 0, false},
+	}
+
+	for _, tc := range tests {
+		// nolint
+		t.Run(tc.testName, func(t *testing.T) {
+			// First request is 200 and expired a minute ago. After that, 500s
+			ts := httptest.NewServer(appendHandlerFunc(
+				getHandlerFunc(http.StatusOK, -time.Minute, nil),
+				getHandlerFunc(http.StatusInternalServerError, 0, nil),
+				new(int32)))
+			defer ts.Close()
+
+			certProv, err := newCachedURLCertsProvider(ts.URL, WithStaleGracePeriod(tc.grace))
+			require.NoError(t, err)
+			certs, err := certProv.GetCerts()
+			if tc.expSuccess {
+				assert.NoError(t, err)
+				assertCertsCorrect(t, certs)
+			} else {
+				assert.Error(t, err)
+				assert.Nil(t, certs)
+			}
+		})
+	}
+}
+
+// getTestCertsHandlerFunc serves the certs returned by loadTestCerts, which verify the tokens of signTestToken
+func getTestCertsHandlerFunc(t testing.TB, expiresIn time.Duration, requestCount *int32) http.HandlerFunc {
+	bCerts, err := json.Marshal(loadTestCerts(t))
+	require.NoError(t, err)
+	return func(w http.ResponseWriter, r *http.Request) {
+		incrementAndGet(requestCount)
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Expires", time.Now().Add(expiresIn).UTC().Format(http.TimeFormat))
+		_, _ = w.Write(bCerts)
+	}
+}
+
+func TestCertsFetcher(t *testing.T) {
+	certs := loadTestCerts(t)
+	var numFetches int32 = 0
+	fetcher := func(ctx context.Context) (*Certs, time.Time, error) {
+		if atomic.AddInt32(&numFetches, 1) == 1 {
+			// first certs are already expired
+			return certs, time.Now().Add(-time.Minute), nil
+		}
+		return certs, time.Now().Add(2 * time.Hour), nil
+	}
+
+	certProv, err := NewCachedURLCertsProviderWithOptions(WithCertsFetcher(fetcher))
+	require.NoError(t, err)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&numFetches))
+	for i := 0; i < 3; i++ {
+		got, err := certProv.GetCerts()
+		require.NoError(t, err)
+		assert.True(t, certs.Equal(got))
+	}
+	// only one refresh after the expired ones
+	assert.Equal(t, int32(2), atomic.LoadInt32(&numFetches))
+
+	verifier := New(certProv)
+	assert.NotNil(t, verifier.Verify(signTestToken(t, testClaims(time.Now())), testAud))
+
+	certProv, err = NewCachedURLCertsProviderWithOptions(WithCertsFetcher(func(ctx context.Context) (*Certs, time.Time, error) {
+		return nil, time.Time{}, errors.New("no certs for you")
+	}))
+	require.NoError(t, err)
+	got, err := certProv.GetCerts()
+	assert.Error(t, err)
+	assert.Nil(t, got)
+	// certs fetched are validated like downloaded ones
+	certProv, err = NewCachedURLCertsProviderWithOptions(WithCertsFetcher(func(ctx context.Context) (*Certs, time.Time, error) {
+		return &Certs{Keys: []Key{{Kty: "RSA", Kid: "no-modulus", E: "AQAB"}}}, time.Now().Add(2 * time.Hour), nil
+	}))
+	require.NoError(t, err)
+	got, err = certProv.GetCerts()
+	assert.True(t, errors.Is(err, ErrInvalidCerts), "got %v", err)
+	assert.Nil(t, got)
+}
+
+func TestCertsAge(t *testing.T) {
+	certs := loadTestCerts(t)
+	certProv, err := NewCachedURLCertsProviderWithOptions(WithCertsFetcher(func(ctx context.Context) (*Certs, time.Time, error) {
+		return certs, time.Now().Add(2 * time.Hour), nil
+	}))
+	require.NoError(t, err)
+
+	firstAge := certProv.CertsAge()
+	time.Sleep(50 * time.Millisecond)
+	age := certProv.CertsAge()
+	assert.GreaterOrEqual(t, int64(age), int64(firstAge+50*time.Millisecond))
+
+	// a refresh resets the age
+	certProv.expireForTest()
+	_, err = certProv.GetCerts()
+	require.NoError(t, err)
+	assert.Less(t, int64(certProv.CertsAge()), int64(50*time.Millisecond))
+
+	failing, err := NewCachedURLCertsProviderWithOptions(WithCertsFetcher(func(ctx context.Context) (*Certs, time.Time, error) {
+		return nil, time.Time{}, errors.New("unavailable")
+	}))
+	require.NoError(t, err)
+	assert.Zero(t, failing.CertsAge())
+}
+
+func TestCertsSnapshot(t *testing.T) {
+	certs := loadTestCerts(t)
+	expires := time.Now().Add(2 * time.Hour).Truncate(time.Second)
+	certProv, err := NewCachedURLCertsProviderWithOptions(WithCertsFetcher(func(ctx context.Context) (*Certs, time.Time, error) {
+		return certs, expires, nil
+	}))
+	require.NoError(t, err)
+
+	snapshot := certProv.Snapshot()
+	assert.True(t, certs.Equal(snapshot.Certs))
+	assert.True(t, expires.Equal(snapshot.Expiry))
+	require.Len(t, snapshot.KeyIDs, len(certs.Keys))
+	for i, key := range certs.Keys {
+		assert.Equal(t, key.Kid, snapshot.KeyIDs[i])
+	}
+	assert.Equal(t, snapshot.KeyIDs, certProv.KeyIDs())
+	assert.True(t, expires.Equal(certProv.Expiry()))
+
+	// the key IDs returned are a copy
+	certProv.KeyIDs()[0] = "changed"
+	assert.Equal(t, certs.Keys[0].Kid, certProv.KeyIDs()[0])
+
+	failing, err := NewCachedURLCertsProviderWithOptions(WithCertsFetcher(func(ctx context.Context) (*Certs, time.Time, error) {
+		return nil, time.Time{}, errors.New("unavailable")
+	}))
+	require.NoError(t, err)
+	assert.Nil(t, failing.Snapshot().Certs)
+	assert.Empty(t, failing.KeyIDs())
+}
+
+// expireForTest makes the current certs expired
+func (prv *CachedURLCertsProvider) expireForTest() {
+	prv.mutex.Lock()
+	defer prv.mutex.Unlock()
+	prv.expires = time.Now().Add(-time.Second)
+}
+
+func TestConcurrentFirstLoad(t *testing.T) {
+	var numRequests int32 = 0
+	ts := httptest.NewServer(getSlowHandlerFunc(http.StatusOK, time.Hour*2, &numRequests, 200*time.Millisecond))
+	defer ts.Close()
+
+	// a cold provider, nothing has been loaded yet
+	certProv := newUnloadedCertsProvider(ts.URL, defaultRefreshBefore)
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			certs, err := certProv.GetCerts()
+			assert.NoError(t, err)
+			assertCertsCorrect(t, certs)
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, int32(1), atomic.LoadInt32(&numRequests))
+}
+
+func TestConcurrentFirstLoadFailure(t *testing.T) {
+	var numRequests int32 = 0
+	ts := httptest.NewServer(getSlowHandlerFunc(http.StatusInternalServerError, 0, &numRequests, 200*time.Millisecond))
+	defer ts.Close()
+
+	certProv := newUnloadedCertsProvider(ts.URL, defaultRefreshBefore)
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			certs, err := certProv.GetCerts()
+			assert.Error(t, err)
+			assert.Nil(t, certs)
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, int32(1), atomic.LoadInt32(&numRequests))
+}
+
+func TestRotationPollInterval(t *testing.T) {
+	firstCerts, err := ioutil.ReadFile(testCertsPath)
+	require.NoError(t, err)
+	rotatedCerts, err := json.Marshal(loadTestCerts(t))
+	require.NoError(t, err)
+
+	var rotated, numFull, numNotModified int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		etag, body := `"v1"`, firstCerts
+		if atomic.LoadInt32(&rotated) == 1 {
+			etag, body = `"v2"`, rotatedCerts
+		}
+		w.Header().Set("Expires", time.Now().Add(time.Hour*2).UTC().Format(http.TimeFormat))
+		w.Header().Set("ETag", etag)
+		if r.Header.Get("If-None-Match") == etag {
+			incrementAndGet(&numNotModified)
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		incrementAndGet(&numFull)
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write(body)
+	}))
+	defer ts.Close()
+
+	certProv, err := newCachedURLCertsProvider(ts.URL, WithRotationPollInterval(20*time.Millisecond))
+	require.NoError(t, err)
+	defer certProv.Close()
+	certs, err := certProv.GetCerts()
+	require.NoError(t, err)
+	assertCertsCorrect(t, certs)
+
+	// while nothing changes, polling only gets not modified responses
+	assert.Eventually(t, func() bool {
+		return atomic.LoadInt32(&numNotModified) >= 2
+	}, time.Second, 10*time.Millisecond)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&numFull))
+	certs, err = certProv.GetCerts()
+	require.NoError(t, err)
+	assertCertsCorrect(t, certs)
+
+	atomic.StoreInt32(&rotated, 1)
+	assert.Eventually(t, func() bool {
+		certs, err := certProv.GetCerts()
+		return err == nil && certs.Equal(loadTestCerts(t))
+	}, time.Second, 10*time.Millisecond)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&numFull))
+
+	// no more requests once closed
+	certProv.Close()
+	certProv.Close()
+	time.Sleep(30 * time.Millisecond)
+	requests := atomic.LoadInt32(&numFull) + atomic.LoadInt32(&numNotModified)
+	time.Sleep(60 * time.Millisecond)
+	assert.Equal(t, requests, atomic.LoadInt32(&numFull)+atomic.LoadInt32(&numNotModified))
+}
+
+func TestRotationPollIntervalValidation(t *testing.T) {
+	_, err := NewCachedURLCertsProviderWithOptions(WithRotationPollInterval(-time.Second))
+	assert.Error(t, err)
+}
+
+// countingTransport counts the requests going through it
+type countingTransport struct {
+	count int32
+}
+
+func (tr *countingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	atomic.AddInt32(&tr.count, 1)
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+func TestHTTPClient(t *testing.T) {
+	ts := httptest.NewServer(getHandlerFunc(http.StatusOK, time.Hour*2, nil))
+	defer ts.Close()
+
+	transport := &countingTransport{}
+	certProv := createDynamicCertProvider(ts.URL, defaultRefreshBefore, WithHTTPClient(&http.Client{Transport: transport}))
+	certs, err := certProv.GetCerts()
+	require.NoError(t, err)
+	assertCertsCorrect(t, certs)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&transport.count))
+
+	// without a client the default one, with a timeout, is used
+	certProv = newUnloadedCertsProvider(ts.URL, defaultRefreshBefore, WithHTTPClient(nil))
+	assert.Same(t, defaultHTTPClient, certProv.client)
+	assert.NotZero(t, defaultHTTPClient.Timeout)
+}
+
+func TestCertsExpiry(t *testing.T) {
+	now := time.Date(2020, 9, 13, 12, 0, 0, 0, time.UTC)
+	expires := now.Add(3 * time.Hour).Format(http.TimeFormat)
+
+	tests := []struct {
+		testName  string
+		header    http.Header
+		expExpiry time.Time
+	}{
+		{"Only Cache-Control", http.Header{"Cache-Control": {"public, max-age=19845, must-revalidate, no-transform"}}, now.Add(19845 * time.Second)},
+		{"Only Expires", http.Header{"Expires": {expires}}, now.Add(3 * time.Hour)},
+		{"Both prefer Cache-Control", http.Header{"Cache-Control": {"public, max-age=3600"}, "Expires": {expires}}, now.Add(time.Hour)},
+		{"Neither", http.Header{}, now.Add(defaultCertsMaxAge)},
+		{"Max-age minus age", http.Header{"Cache-Control": {"max-age=3600"}, "Age": {"600"}}, now.Add(50 * time.Minute)},
+		{"No-cache", http.Header{"Cache-Control": {"max-age=3600, no-cache"}, "Expires": {expires}}, now},
+		{"Malformed max-age falls back to Expires", http.Header{"Cache-Control": {"max-age=soon"}, "Expires": {expires}}, now.Add(3 * time.Hour)},
+		{"Malformed Expires", http.Header{"Expires": {"tomorrow"}}, now.Add(defaultCertsMaxAge)},
+	}
+
+	for _, tc := range tests {
+		// nolint
+		t.Run(tc.testName, func(t *testing.T) {
+			assert.Equal(t, tc.expExpiry, certsExpiry(tc.header, now))
+		})
+	}
+}
+
+func TestCertsWithoutExpires(t *testing.T) {
+	bCerts, err := ioutil.ReadFile(testCertsPath)
+	require.NoError(t, err)
+
+	tests := []struct {
+		testName  string
+		header    http.Header
+		expExpiry time.Duration
+	}{
+		{"Only Cache-Control", http.Header{"Cache-Control": {"public, max-age=7200"}}, 2 * time.Hour},
+		{"No caching headers", http.Header{}, defaultCertsMaxAge},
+		{"Unparseable Expires", http.Header{"Expires": {"in two hours"}}, defaultCertsMaxAge},
+	}
+
+	for _, tc := range tests {
+		// nolint
+		t.Run(tc.testName, func(t *testing.T) {
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				for name, values := range tc.header {
+					w.Header()[name] = values
+				}
+				_, _ = w.Write(bCerts)
+			}))
+			defer ts.Close()
+
+			certProv, err := newCachedURLCertsProvider(ts.URL)
+			require.NoError(t, err)
+			certs, err := certProv.GetCerts()
+			require.NoError(t, err)
+			assertCertsCorrect(t, certs)
+			assert.WithinDuration(t, time.Now().Add(tc.expExpiry), certProv.Expiry(), time.Minute)
+		})
+	}
+}
+
+func TestRefreshBeforeWindow(t *testing.T) {
+	tests := []struct {
+		testName      string
+		refreshBefore time.Duration
+		expRefresh    bool
+	}{
+		{"Certs expiring in 5 minutes, 10 minutes window", -10 * time.Minute, true},
+		{"Certs expiring in 5 minutes, 1 minute window", -time.Minute, false},
+		{"Certs expiring in 5 minutes, background refresh disabled", 0, false},
+	}
+
+	for _, tc := range tests {
+		// nolint
+		t.Run(tc.testName, func(t *testing.T) {
+			certs := loadTestCerts(t)
+			var numFetches int32
+			certProv, err := NewCachedURLCertsProviderWithOptions(WithRefreshBefore(tc.refreshBefore),
+				WithCertsFetcher(func(ctx context.Context) (*Certs, time.Time, error) {
+					atomic.AddInt32(&numFetches, 1)
+					return certs, time.Now().Add(5 * time.Minute), nil
+				}))
+			require.NoError(t, err)
+
+			_, err = certProv.GetCerts()
+			require.NoError(t, err)
+			if tc.expRefresh {
+				assert.Eventually(t, func() bool {
+					return atomic.LoadInt32(&numFetches) == 2
+				}, time.Second, 10*time.Millisecond)
+				// refreshed certs still inside the window are refreshed halfway through their lifetime
+				_, err = certProv.GetCerts()
+				require.NoError(t, err)
+				time.Sleep(50 * time.Millisecond)
+				assert.Equal(t, int32(2), atomic.LoadInt32(&numFetches))
+				assert.WithinDuration(t, time.Now().Add(150*time.Second), certProv.refreshTime(), 10*time.Second)
+				return
+			}
+			time.Sleep(50 * time.Millisecond)
+			assert.Equal(t, int32(1), atomic.LoadInt32(&numFetches))
+		})
+	}
+}
+
+func TestRefreshJitter(t *testing.T) {
+	certs := loadTestCerts(t)
+	// long-lived enough for the refresh window to start after the halfway of their lifetime
+	expires := time.Now().Add(3 * time.Hour)
+	certProv := newUnloadedCertsProvider(testCertsPath, -time.Hour, WithRefreshJitter(0.1))
+	require.NoError(t, certProv.validate())
+
+	earliest, latest := expires, time.Time{}
+	for i := 0; i < 100; i++ {
+		certProv.storeCerts(certs, expires)
+		refreshAt := certProv.refreshTime()
+		assert.False(t, refreshAt.Before(expires.Add(-66*time.Minute)), "refresh at %v", refreshAt)
+		assert.False(t, refreshAt.After(expires.Add(-54*time.Minute)), "refresh at %v", refreshAt)
+		if refreshAt.Before(earliest) {
+			earliest = refreshAt
+		}
+		if refreshAt.After(latest) {
+			latest = refreshAt
+		}
+	}
+	assert.Greater(t, int64(latest.Sub(earliest)), int64(6*time.Minute), "refreshes must be spread")
+
+	// without jitter the refresh starts exactly at the window
+	certProv = newUnloadedCertsProvider(testCertsPath, -time.Hour)
+	certProv.storeCerts(certs, expires)
+	assert.Equal(t, expires.Add(-time.Hour), certProv.refreshTime())
+
+	for _, jitter := range []float64{-0.1, 1.5} {
+		_, err := NewCachedURLCertsProviderWithOptions(WithRefreshJitter(jitter))
+		assert.Error(t, err)
+	}
+}
+
+func TestCachedURLCertsProviderWithURL(t *testing.T) {
+	var numRequests int32 = 0
+	ts := httptest.NewServer(getHandlerFunc(http.StatusOK, time.Hour*2, &numRequests))
+	defer ts.Close()
+
+	certProv, err := NewCachedURLCertsProviderWithURL(ts.URL, WithRefreshBefore(-10*time.Minute))
+	require.NoError(t, err)
+	certs, err := certProv.GetCerts()
+	require.NoError(t, err)
+	assertCertsCorrect(t, certs)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&numRequests))
+	assert.Equal(t, ts.URL, certProv.Config().URL)
+	assert.Equal(t, -10*time.Minute, certProv.Config().RefreshBefore)
+
+	for _, rawUrl := range []string{"", "/oauth2/v3/certs", "ftp://example.com/certs", "https://", "http://[::1"} {
+		_, err := NewCachedURLCertsProviderWithURL(rawUrl)
+		assert.Error(t, err, rawUrl)
+	}
+	_, err = NewCachedURLCertsProviderWithURL(ts.URL, WithRefreshBefore(time.Hour))
+	assert.Error(t, err)
+}
+
+func TestGetCertsContext(t *testing.T) {
+	var numRequests int32 = 0
+	ts := httptest.NewServer(getSlowHandlerFunc(http.StatusOK, time.Hour*2, &numRequests, 300*time.Millisecond))
+	defer ts.Close()
+
+	// an already cancelled context does not even start the refresh
+	certProv := newUnloadedCertsProvider(ts.URL, defaultRefreshBefore)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	certs, err := certProv.GetCertsContext(ctx)
+	assert.True(t, errors.Is(err, context.Canceled), "got %v", err)
+	assert.Nil(t, certs)
+	assert.Equal(t, int32(0), atomic.LoadInt32(&numRequests))
+
+	// the deadline applies to the refresh
+	ctx, cancel = context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	certs, err = certProv.GetCertsContext(ctx)
+	assert.Less(t, int64(time.Since(start)), int64(250*time.Millisecond))
+	assert.True(t, errors.Is(err, context.DeadlineExceeded), "got %v", err)
+	assert.Nil(t, certs)
+
+	certs, err = certProv.GetCertsContext(context.Background())
+	require.NoError(t, err)
+	assertCertsCorrect(t, certs)
+
+	// valid certs are returned whatever the context
+	ctx, cancel = context.WithCancel(context.Background())
+	cancel()
+	certs, err = certProv.GetCertsContext(ctx)
+	require.NoError(t, err)
+	assertCertsCorrect(t, certs)
+
+	staticProvider := NewStaticCertsProvider()
+	require.NoError(t, staticProvider.LoadFromFile(testCertsPath))
+	certs, err = staticProvider.GetCertsContext(ctx)
+	require.NoError(t, err)
+	assertCertsCorrect(t, certs)
+}
+
+// getFailingFirstHandlerFunc answers failures with statusCode times, the certs after that
+func getFailingFirstHandlerFunc(failures int32, statusCode int, requestCount *int32) http.HandlerFunc {
+	succeed := getHandlerFunc(http.StatusOK, time.Hour*2, nil)
+	return func(w http.ResponseWriter, r *http.Request) {
+		if incrementAndGet(requestCount) <= failures {
+			w.WriteHeader(statusCode)
+			return
+		}
+		succeed(w, r)
+	}
+}
+
+func TestFetchRetries(t *testing.T) {
+	tests := []struct {
+		testName    string
+		failures    int32
+		statusCode  int
+		attempts    int
+		expSuccess  bool
+		expRequests int32
+	}{
+		{"Fails twice then succeeds", 2, http.StatusInternalServerError, 3, true, 3},
+		{"Too many failures", 3, http.StatusInternalServerError, 3, false, 3},
+		{"Too many requests is retried", 1, http.StatusTooManyRequests, 3, true, 2},
+		{"Client errors are permanent", 1, http.StatusNotFound, 3, false, 1},
+		{"No retries", 1, http.StatusInternalServerError, 1, false, 1},
+	}
+
+	for _, tc := range tests {
+		// nolint
+		t.Run(tc.testName, func(t *testing.T) {
+			var numRequests int32 = 0
+			ts := httptest.NewServer(getFailingFirstHandlerFunc(tc.failures, tc.statusCode, &numRequests))
+			defer ts.Close()
+
+			certProv := newUnloadedCertsProvider(ts.URL, defaultRefreshBefore, WithFetchRetries(tc.attempts, 5*time.Millisecond, 20*time.Millisecond))
+			certs, err := certProv.GetCerts()
+			if tc.expSuccess {
+				require.NoError(t, err)
+				assertCertsCorrect(t, certs)
+			} else {
+				assert.Error(t, err)
+			}
+			assert.Equal(t, tc.expRequests, atomic.LoadInt32(&numRequests))
+		})
+	}
+}
+
+func TestFetchRetriesPermanentAndCancelled(t *testing.T) {
+	var numRequests int32 = 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		incrementAndGet(&numRequests)
+		_, _ = w.Write([]byte("not json"))
+	}))
+	defer ts.Close()
+	certProv := newUnloadedCertsProvider(ts.URL, defaultRefreshBefore, WithFetchRetries(5, 5*time.Millisecond, 20*time.Millisecond))
+	assert.Error(t, certProv.updateCerts(context.Background()))
+	assert.Equal(t, int32(1), atomic.LoadInt32(&numRequests), "malformed bodies are not retried")
+
+	var failing int32 = 0
+	ts2 := httptest.NewServer(getFailingFirstHandlerFunc(100, http.StatusInternalServerError, &failing))
+	defer ts2.Close()
+	certProv = newUnloadedCertsProvider(ts2.URL, defaultRefreshBefore, WithFetchRetries(100, 200*time.Millisecond, time.Second))
+	// the retries go on in background until the provider is closed
+	defer certProv.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	assert.Error(t, certProv.updateCerts(ctx))
+	assert.Less(t, int64(time.Since(start)), int64(200*time.Millisecond))
+	assert.Equal(t, int32(1), atomic.LoadInt32(&failing))
+}
+
+func TestFetchRetriesValidation(t *testing.T) {
+	for _, opt := range []CertsProviderOption{
+		WithFetchRetries(0, time.Millisecond, time.Second),
+		WithFetchRetries(3, 0, time.Second),
+		WithFetchRetries(3, time.Second, time.Millisecond),
+	} {
+		_, err := NewCachedURLCertsProviderWithOptions(opt)
+		assert.Error(t, err)
+	}
+
+	prv := newUnloadedCertsProvider("", defaultRefreshBefore, WithFetchRetries(10, 10*time.Millisecond, 100*time.Millisecond))
+	for attempt := 1; attempt < 10; attempt++ {
+		backoff := 10 * time.Millisecond << (attempt - 1)
+		if backoff > 100*time.Millisecond {
+			backoff = 100 * time.Millisecond
+		}
+		delay := prv.retryDelay(attempt)
+		assert.GreaterOrEqual(t, int64(delay), int64(backoff/2))
+		assert.LessOrEqual(t, int64(delay), int64(backoff))
+	}
+
+	// the backoff of late attempts is capped instead of overflowing
+	prv = newUnloadedCertsProvider("", defaultRefreshBefore, WithFetchRetries(100, time.Minute, 10*time.Minute))
+	for _, attempt := range []int{29, 30, 33, 64, 100} {
+		delay := prv.retryDelay(attempt)
+		assert.GreaterOrEqual(t, int64(delay), int64(5*time.Minute), "attempt %d", attempt)
+		assert.LessOrEqual(t, int64(delay), int64(10*time.Minute), "attempt %d", attempt)
+	}
+}
+
+func TestConcurrentGetCertsDuringExpiry(t *testing.T) {
+	var numRequests int32 = 0
+	ts := httptest.NewServer(getSlowHandlerFunc(http.StatusOK, time.Hour*2, &numRequests, 100*time.Millisecond))
+	defer ts.Close()
+	certProv, err := newCachedURLCertsProvider(ts.URL)
+	require.NoError(t, err)
+	require.Equal(t, int32(1), atomic.LoadInt32(&numRequests))
+
+	for round := 0; round < 3; round++ {
+		certProv.expireForTest()
+		var wg sync.WaitGroup
+		for i := 0; i < 100; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				certs, err := certProv.GetCerts()
+				assert.NoError(t, err)
+				assertCertsCorrect(t, certs)
+			}()
+		}
+		wg.Wait()
+		assert.Equal(t, int32(round+2), atomic.LoadInt32(&numRequests), "a single fetch per expiry")
+	}
+}
+
+func TestSharedFetchOutlivesCaller(t *testing.T) {
+	var numRequests int32 = 0
+	ts := httptest.NewServer(getSlowHandlerFunc(http.StatusOK, time.Hour*2, &numRequests, 200*time.Millisecond))
+	defer ts.Close()
+	certProv := newUnloadedCertsProvider(ts.URL, defaultRefreshBefore)
+
+	// the caller starting the fetch gives up while another one is waiting for it
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		_, err := certProv.GetCertsContext(ctx)
+		assert.True(t, errors.Is(err, context.DeadlineExceeded), "got %v", err)
+	}()
+	time.Sleep(5 * time.Millisecond)
+	certs, err := certProv.GetCertsContext(context.Background())
+	wg.Wait()
+	require.NoError(t, err)
+	assert.NotNil(t, certs)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&numRequests))
+}
+
+func TestSyncRefreshJoinsBackgroundRefresh(t *testing.T) {
+	var numRequests int32 = 0
+	sleepTime := 300 * time.Millisecond
+	ts := httptest.NewServer(appendHandlerFunc(
+		getHandlerFunc(http.StatusOK, 10*time.Minute, nil),
+		getSlowHandlerFunc(http.StatusOK, time.Hour*2, nil, sleepTime),
+		&numRequests))
+	defer ts.Close()
+	certProv := createDynamicCertProvider(ts.URL, defaultRefreshBefore)
+
+	// within the refresh window, a background refresh starts
+	certs, err := certProv.GetCerts()
+	require.NoError(t, err)
+	assertCertsCorrect(t, certs)
+	assert.Eventually(t, func() bool {
+		return atomic.LoadInt32(&numRequests) == int32(2)
+	}, sleepTime, 5*time.Millisecond)
+
+	// expired certs wait for the refresh in flight instead of starting another one or failing
+	certProv.expireForTest()
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			certs, err := certProv.GetCerts()
+			assert.NoError(t, err)
+			assertCertsCorrect(t, certs)
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, int32(2), atomic.LoadInt32(&numRequests))
+	_, expires, _ := certProv.snapshot()
+	assert.WithinDuration(t, time.Now().Add(2*time.Hour), expires, time.Minute, "the certs of the shared refresh")
+}
+
+func TestBackgroundRefresh(t *testing.T) {
+	var numRequests int32 = 0
+	bCerts, err := ioutil.ReadFile(testCertsPath)
+	require.NoError(t, err)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		incrementAndGet(&numRequests)
+		w.Header().Set("Cache-Control", "public, max-age=1")
+		_, _ = w.Write(bCerts)
+	}))
+	defer ts.Close()
+
+	certProv := newUnloadedCertsProvider(ts.URL, 0, WithBackgroundRefresh(), func(prv *CachedURLCertsProvider) {
+		prv.backgroundRetryDelay = 50 * time.Millisecond
+	})
+	certProv.start()
+	defer certProv.Close()
+	require.Equal(t, int32(1), atomic.LoadInt32(&numRequests))
+
+	// no GetCerts call, the certs are refreshed every second on their own
+	assert.Eventually(t, func() bool {
+		return atomic.LoadInt32(&numRequests) >= 3
+	}, 5*time.Second, 20*time.Millisecond)
+
+	certProv.Close()
+	certProv.Close()
+	time.Sleep(100 * time.Millisecond)
+	requests := atomic.LoadInt32(&numRequests)
+	time.Sleep(1500 * time.Millisecond)
+	assert.Equal(t, requests, atomic.LoadInt32(&numRequests), "no refresh once closed")
+}
+
+func TestBackgroundRefreshShortLivedCerts(t *testing.T) {
+	var numRequests int32 = 0
+	bCerts, err := ioutil.ReadFile(testCertsPath)
+	require.NoError(t, err)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		incrementAndGet(&numRequests)
+		w.Header().Set("Cache-Control", "public, max-age=2")
+		_, _ = w.Write(bCerts)
+	}))
+	defer ts.Close()
+
+	// the certs live less than the refresh window, and the delay after failures is never reached
+	certProv := newUnloadedCertsProvider(ts.URL, defaultRefreshBefore, WithBackgroundRefresh(), func(prv *CachedURLCertsProvider) {
+		prv.backgroundRetryDelay = time.Hour
+	})
+	certProv.start()
+	defer certProv.Close()
+
+	// they are refreshed halfway through their lifetime, neither in a loop nor after the delay
+	time.Sleep(2500 * time.Millisecond)
+	requests := atomic.LoadInt32(&numRequests)
+	assert.GreaterOrEqual(t, requests, int32(3))
+	assert.LessOrEqual(t, requests, int32(5))
+}
+
+func TestCertsFetchError(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Retry-After", "120")
+		w.WriteHeader(http.StatusServiceUnavailable)
+		_, _ = w.Write([]byte("retry in 2 minutes"))
+		_, _ = w.Write(bytes.Repeat([]byte("x"), 10*maxCertsErrorBodyBytes))
+	}))
+	defer ts.Close()
+
+	certProv := newUnloadedCertsProvider(ts.URL, defaultRefreshBefore)
+	_, err := certProv.GetCerts()
+	var fetchErr *CertsFetchError
+	require.True(t, errors.As(err, &fetchErr))
+	assert.Equal(t, http.StatusServiceUnavailable, fetchErr.StatusCode)
+	assert.Equal(t, ts.URL, fetchErr.URL)
+	assert.True(t, strings.HasPrefix(fetchErr.Body, "retry in 2 minutes"))
+	assert.Len(t, fetchErr.Body, maxCertsErrorBodyBytes)
+
+	// client errors, which are not retried, are CertsFetchError too
+	ts404 := httptest.NewServer(http.NotFoundHandler())
+	defer ts404.Close()
+	_, err = newUnloadedCertsProvider(ts404.URL, defaultRefreshBefore).GetCerts()
+	require.True(t, errors.As(err, &fetchErr))
+	assert.Equal(t, http.StatusNotFound, fetchErr.StatusCode)
+}
+
+func TestCertsResponseTooLarge(t *testing.T) {
+	bCerts, err := ioutil.ReadFile(testCertsPath)
+	require.NoError(t, err)
+	// the certs are followed by 64 MiB of whitespace, streamed until the client gives up
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write(bCerts)
+		chunk := bytes.Repeat([]byte(" "), 64*1024)
+		for i := 0; i < 1024; i++ {
+			if _, err := w.Write(chunk); err != nil {
+				return
+			}
+		}
+	}))
+	defer ts.Close()
+
+	certs, err := newUnloadedCertsProvider(ts.URL, defaultRefreshBefore).GetCerts()
+	assert.Nil(t, certs)
+	assert.True(t, errors.Is(err, ErrCertsResponseTooLarge), "got %v", err)
+
+	// certs up to the limit are loaded
+	padded := append(append([]byte{}, bCerts...), bytes.Repeat([]byte(" "), maxCertsBodyBytes-len(bCerts))...)
+	ts2 := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write(padded)
+	}))
+	defer ts2.Close()
+	certs, err = newUnloadedCertsProvider(ts2.URL, defaultRefreshBefore).GetCerts()
+	require.NoError(t, err)
+	assertCertsCorrect(t, certs)
+}
+
+func TestNotModifiedCerts(t *testing.T) {
+	bCerts, err := ioutil.ReadFile(testCertsPath)
+	require.NoError(t, err)
+	lastModified := time.Now().Add(-time.Hour).UTC().Format(http.TimeFormat)
+
+	var numFull, numNotModified int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "public, max-age=3600")
+		w.Header().Set("Last-Modified", lastModified)
+		if r.Header.Get("If-Modified-Since") == lastModified {
+			incrementAndGet(&numNotModified)
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		incrementAndGet(&numFull)
+		_, _ = w.Write(bCerts)
+	}))
+	defer ts.Close()
+
+	certProv, err := newCachedURLCertsProvider(ts.URL, WithSynchronousRefresh())
+	require.NoError(t, err)
+	certs, err := certProv.GetCerts()
+	require.NoError(t, err)
+	time.Sleep(50 * time.Millisecond)
+	assert.GreaterOrEqual(t, int64(certProv.CertsAge()), int64(50*time.Millisecond))
+
+	// the certs expire, the refresh keeps the parsed certs and extends their expiry
+	certProv.mutex.Lock()
+	certProv.expires = time.Now().Add(-time.Second)
+	certProv.mutex.Unlock()
+	refreshed, err := certProv.GetCerts()
+	require.NoError(t, err)
+	assert.Same(t, certs, refreshed)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&numFull))
+	assert.Equal(t, int32(1), atomic.LoadInt32(&numNotModified))
+	_, expires, _ := certProv.snapshot()
+	assert.WithinDuration(t, time.Now().Add(time.Hour), expires, time.Minute)
+	// and resets their age
+	assert.Less(t, int64(certProv.CertsAge()), int64(50*time.Millisecond))
+}
+
+func TestOfflineCertsProvider(t *testing.T) {
+	var numRequests int32
+	ts := httptest.NewServer(getHandlerFunc(http.StatusOK, time.Hour*2, &numRequests))
+	defer ts.Close()
+
+	_, err := NewCachedURLCertsProviderWithURL(ts.URL, WithOffline())
+	assert.True(t, errors.Is(err, ErrOffline), "got %v", err)
+
+	certs := loadTestCerts(t)
+	certProv, err := NewCachedURLCertsProviderWithURL(ts.URL, WithOffline(), WithSynchronousRefresh(),
+		WithCertsFetcher(func(ctx context.Context) (*Certs, time.Time, error) {
+			return certs, time.Now().Add(time.Hour), nil
+		}))
+	require.NoError(t, err)
+	got, err := certProv.GetCerts()
+	require.NoError(t, err)
+	assert.True(t, certs.Equal(got))
+
+	// providers created without validation do not download the certs either
+	unloaded := newUnloadedCertsProvider(ts.URL, defaultRefreshBefore, WithOffline(), WithFetchRetries(3, time.Millisecond, time.Millisecond))
+	_, err = unloaded.GetCerts()
+	assert.True(t, errors.Is(err, ErrOffline), "got %v", err)
+	assert.Zero(t, atomic.LoadInt32(&numRequests))
+}
@@ -0,0 +1,113 @@
+package GoogleIdTokenVerifier
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCertsEqual(t *testing.T) {
+	staticProvider := NewStaticCertsProvider()
+	require.NoError(t, staticProvider.LoadFromFile(testCertsPath))
+	certs, err := staticProvider.GetCerts()
+	require.NoError(t, err)
+
+	same := &Certs{Keys: []Key{certs.Keys[0], certs.Keys[1]}}
+	reordered := &Certs{Keys: []Key{certs.Keys[1], certs.Keys[0]}}
+	fewer := &Certs{Keys: []Key{certs.Keys[0]}}
+	changedKey := certs.Keys[1]
+	changedKey.N = certs.Keys[0].N
+	changed := &Certs{Keys: []Key{certs.Keys[0], changedKey}}
+	duplicated := &Certs{Keys: []Key{certs.Keys[0], certs.Keys[0]}}
+
+	assert.True(t, certs.Equal(same))
+	assert.True(t, certs.Equal(reordered))
+	assert.True(t, reordered.Equal(certs))
+	assert.False(t, certs.Equal(fewer))
+	assert.False(t, certs.Equal(changed))
+	assert.False(t, certs.Equal(duplicated))
+	assert.False(t, duplicated.Equal(certs))
+	assert.False(t, certs.Equal(nil))
+
+	var nilCerts *Certs
+	assert.True(t, nilCerts.Equal(nil))
+}
+
+func TestKeyJSON(t *testing.T) {
+	// a key as published at GoogleCertsURL, with the member names of RFC 7517
+	googleKey := `{"e": "AQAB", "kty": "RSA", "alg": "RS256", "use": "sig", "kid": "6a8ba5652a7044121d4fedac8f14d14c54e4895b", "n": "o76AudS2rsCvlz_3D47sFkpuz3NJxgLbXr1cHdmbo9xOMttPMJI97f0rHiSl9stltMi87KIOEEVQWUgMLaWQNaIZThgI1seWDAGRw59AO5sctgM1wPVZYt40fj2Qw4KT7m4RLMsZV1M5NYMwsHA"}`
+	var certs Certs
+	require.NoError(t, json.Unmarshal([]byte(`{"keys": [`+googleKey+`]}`), &certs))
+	require.Len(t, certs.Keys, 1)
+	assert.Equal(t, "6a8ba5652a7044121d4fedac8f14d14c54e4895b", certs.Keys[0].Kid)
+	assert.Equal(t, "RS256", certs.Keys[0].Alg)
+
+	// keys are encoded back with the same member names
+	encoded, err := json.Marshal(certs.Keys[0])
+	require.NoError(t, err)
+	assert.JSONEq(t, googleKey, string(encoded))
+}
+
+func TestDecodeCerts(t *testing.T) {
+	data, err := ioutil.ReadFile(testCertsPath)
+	require.NoError(t, err)
+	const rsaKey = `{"kty": "RSA", "kid": "rsa-kid", "n": "o76AudS2rsCvlz", "e": "AQAB"}`
+	const ecKey = `{"kty": "EC", "kid": "ec-kid", "crv": "P-256", "x": "f83OJ3D2xF1Bg8vub9tLe1gHMzV76e8Tus9uPHvRVEU", "y": "x_FEzRu9m36HLN_tue659LNpXW6pCyStikYjKIWI5a0"}`
+
+	tests := []struct {
+		testName string
+		json     string
+		expKeys  int
+		expError string
+	}{
+		{"Google certs", string(data), 2, ""},
+		{"EC key", `{"keys": [` + ecKey + `]}`, 1, ""},
+		{"Key of another type", `{"keys": [{"kty": "oct", "kid": "secret"}, ` + rsaKey + `]}`, 2, ""},
+		{"Error page", "<html><body>Service Unavailable</body></html>", 0, "invalid character"},
+		{"Truncated JSON", string(data[:len(data)/2]), 0, "unexpected end of JSON input"},
+		{"No keys", `{"keys": []}`, 0, "there are no RSA nor EC keys among the 0 keys"},
+		{"Empty object", `{}`, 0, "there are no RSA nor EC keys"},
+		{"Only keys of another type", `{"keys": [{"kty": "oct", "kid": "secret"}]}`, 0, "there are no RSA nor EC keys among the 1 keys"},
+		{"Missing kid", `{"keys": [{"kty": "RSA", "n": "o76AudS2rsCvlz", "e": "AQAB"}]}`, 0, `key 0 (kid "") has no kid`},
+		{"Missing n", `{"keys": [` + rsaKey + `, {"kty": "RSA", "kid": "no-n", "e": "AQAB"}]}`, 0, `key 1 (kid "no-n") has no n`},
+		{"Missing e", `{"keys": [{"kty": "RSA", "kid": "no-e", "n": "o76AudS2rsCvlz"}]}`, 0, `key 0 (kid "no-e") has no e`},
+		{"Missing EC coordinate", `{"keys": [{"kty": "EC", "kid": "no-y", "crv": "P-256", "x": "f83OJ3D2xF1Bg8vub9tLe1gHMzV76e8Tus9uPHvRVEU"}]}`, 0, `key 0 (kid "no-y") has no y`},
+	}
+
+	for _, tc := range tests {
+		// nolint
+		t.Run(tc.testName, func(t *testing.T) {
+			certs, err := DecodeCerts([]byte(tc.json))
+			if tc.expError != "" {
+				assert.Nil(t, certs)
+				assert.True(t, errors.Is(err, ErrInvalidCerts), "got %v", err)
+				assert.Contains(t, err.Error(), tc.expError)
+				return
+			}
+			require.NoError(t, err)
+			assert.Len(t, certs.Keys, tc.expKeys)
+		})
+	}
+
+	// GetCerts decodes certs the same way
+	_, err = GetCerts([]byte(`{"keys": []}`))
+	assert.True(t, errors.Is(err, ErrInvalidCerts), "got %v", err)
+	certs, err := GetCerts(data)
+	require.NoError(t, err)
+	assert.Len(t, certs.Keys, 2)
+
+	// certs served with 200 OK that are not valid are not loaded
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"keys": []}`))
+	}))
+	defer ts.Close()
+	_, err = newUnloadedCertsProvider(ts.URL, defaultRefreshBefore).GetCerts()
+	assert.True(t, errors.Is(err, ErrInvalidCerts), "got %v", err)
+	assert.Error(t, NewStaticCertsProvider().LoadFromBytes([]byte(`{"keys": []}`)))
+}
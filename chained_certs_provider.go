@@ -0,0 +1,42 @@
+package GoogleIdTokenVerifier
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// ChainedCertsProvider gets the certs from the first of its providers that has them, so a
+// pinned StaticCertsProvider can back the Google certs URL during an outage
+type ChainedCertsProvider struct {
+	providers []CertsProvider
+}
+
+// NewChainedCertsProvider returns a CertsProvider trying providers in order
+func NewChainedCertsProvider(providers ...CertsProvider) *ChainedCertsProvider {
+	return &ChainedCertsProvider{providers: providers}
+}
+
+func (prv *ChainedCertsProvider) GetCerts() (*Certs, error) {
+	return prv.GetCertsContext(context.Background())
+}
+
+// GetCertsContext returns the certs of the first provider returning them without error. If
+// all the providers fail, the error joins the errors of every provider.
+func (prv *ChainedCertsProvider) GetCertsContext(ctx context.Context) (*Certs, error) {
+	if len(prv.providers) == 0 {
+		return nil, errors.New("no certs providers in the chain")
+	}
+	errs := make([]error, 0, len(prv.providers))
+	for i, provider := range prv.providers {
+		certs, err := provider.GetCertsContext(ctx)
+		if err == nil && certs != nil {
+			return certs, nil
+		}
+		if err == nil {
+			err = errors.New("no certs")
+		}
+		errs = append(errs, fmt.Errorf("certs provider %d: %w", i, err))
+	}
+	return nil, errors.Join(errs...)
+}
@@ -0,0 +1,51 @@
+package GoogleIdTokenVerifier
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestChainedCertsProvider(t *testing.T) {
+	pinned := &StaticCertsProvider{certs: loadTestCerts(t)}
+
+	certs, err := NewChainedCertsProvider(failingCertsProvider{}, NewStaticCertsProvider(), pinned).GetCerts()
+	require.NoError(t, err)
+	assert.Same(t, pinned.certs, certs)
+
+	// tokens signed by a pinned key are verified while the first provider is down
+	verifier := New(NewChainedCertsProvider(failingCertsProvider{}, pinned))
+	_, err = verifier.VerifyE(signTestToken(t, testClaims(time.Now())), testAud)
+	assert.NoError(t, err)
+
+	certs, err = NewChainedCertsProvider(failingCertsProvider{}, NewStaticCertsProvider()).GetCerts()
+	assert.Nil(t, certs)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "certs are down")
+	assert.Contains(t, err.Error(), "certs provider 1: no certs")
+
+	// every error can be matched
+	down := errors.New("down")
+	_, err = NewChainedCertsProvider(NewStaticCertsProvider(), errCertsProvider{down}).GetCerts()
+	assert.True(t, errors.Is(err, down))
+
+	_, err = NewChainedCertsProvider().GetCerts()
+	assert.Error(t, err)
+}
+
+// errCertsProvider always fails with err
+type errCertsProvider struct {
+	err error
+}
+
+func (prv errCertsProvider) GetCerts() (*Certs, error) {
+	return nil, prv.err
+}
+
+func (prv errCertsProvider) GetCertsContext(ctx context.Context) (*Certs, error) {
+	return nil, prv.err
+}
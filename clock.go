@@ -0,0 +1,15 @@
+package GoogleIdTokenVerifier
+
+import "time"
+
+// Clock tells the current time to the verifier, see WithClock
+type Clock interface {
+	Now() time.Time
+}
+
+// realClock is the default Clock, the time of the system
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
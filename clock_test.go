@@ -0,0 +1,68 @@
+package GoogleIdTokenVerifier
+
+import (
+	"errors"
+	"io/ioutil"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testFixedTokenPath is a token signed with the test key, issued at testFixedTokenIat and valid for an hour
+const testFixedTokenPath = "testdata/fixed_token.jwt"
+
+var testFixedTokenIat = time.Unix(1600000000, 0)
+
+type fixedClock time.Time
+
+func (c fixedClock) Now() time.Time {
+	return time.Time(c)
+}
+
+func loadTestFixedToken(t testing.TB) string {
+	bt, err := ioutil.ReadFile(testFixedTokenPath)
+	require.NoError(t, err)
+	return strings.TrimSpace(string(bt))
+}
+
+func TestClock(t *testing.T) {
+	authToken := loadTestFixedToken(t)
+	exp := testFixedTokenIat.Add(time.Hour)
+
+	tests := []struct {
+		testName string
+		now      time.Time
+		expErr   error
+	}{
+		{"At iat", testFixedTokenIat, nil},
+		{"Within validity", testFixedTokenIat.Add(30 * time.Minute), nil},
+		{"At exp", exp, nil},
+		{"Within leeway after exp", exp.Add(defaultLeeway), nil},
+		{"After exp and leeway", exp.Add(defaultLeeway + time.Second), ErrTokenExpired},
+		{"Within leeway before iat", testFixedTokenIat.Add(-defaultLeeway), nil},
+		{"Before iat and leeway", testFixedTokenIat.Add(-defaultLeeway - time.Second), ErrTokenNotYetValid},
+		{"System time", time.Time{}, ErrTokenExpired},
+	}
+
+	for _, tc := range tests {
+		// nolint
+		t.Run(tc.testName, func(t *testing.T) {
+			var opts []Option
+			if !tc.now.IsZero() {
+				opts = append(opts, WithClock(fixedClock(tc.now)))
+			}
+			verifier := New(&StaticCertsProvider{certs: loadTestCerts(t)}, opts...)
+			tokeninfo, err := verifier.VerifyE(authToken, testAud)
+			if tc.expErr == nil {
+				require.NoError(t, err)
+				assert.Equal(t, testFixedTokenIat.Unix(), tokeninfo.Iat)
+				return
+			}
+			assert.Nil(t, tokeninfo)
+			assert.True(t, errors.Is(err, tc.expErr), "got %v", err)
+		})
+	}
+}
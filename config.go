@@ -0,0 +1,60 @@
+package GoogleIdTokenVerifier
+
+import "time"
+
+// VerifierConfig is a read-only snapshot of the effective configuration of a GoogleTokenVerifier
+type VerifierConfig struct {
+	Issuers                  []string
+	AudienceMatchMode        AudienceMatchMode
+	TrialVerificationMaxKeys int
+	ReplayDetection          bool
+	Leeway                   time.Duration
+	// VerifyTimeout is zero when verifications are not capped, see WithVerifyTimeout
+	VerifyTimeout time.Duration
+	// CertsProvider is nil when the certs do not come from a CachedURLCertsProvider
+	CertsProvider *CertsProviderConfig
+}
+
+// CertsProviderConfig is a read-only snapshot of the effective configuration of a CachedURLCertsProvider
+type CertsProviderConfig struct {
+	// URL is the one the certs are downloaded from, the jwks_uri resolved for providers using discovery
+	URL string
+	// DiscoveryURL is the URL of the discovery document, empty unless built with NewDiscoveryCertsProvider
+	DiscoveryURL       string
+	RefreshBefore      time.Duration
+	FetchTimeout       time.Duration
+	StaleGracePeriod   time.Duration
+	SynchronousRefresh bool
+}
+
+// Config returns the current settings of the verifier, meant for debugging and diagnostics
+func (v *GoogleTokenVerifier) Config() VerifierConfig {
+	cfg := VerifierConfig{
+		Issuers:                  append([]string(nil), v.issuers...),
+		AudienceMatchMode:        v.audienceMatchMode,
+		TrialVerificationMaxKeys: v.trialMaxKeys,
+		ReplayDetection:          v.replayDetector != nil,
+		Leeway:                   v.leeway,
+		VerifyTimeout:            v.verifyTimeout,
+	}
+	if prv, ok := v.certProvider.(*CachedURLCertsProvider); ok {
+		prvCfg := prv.Config()
+		cfg.CertsProvider = &prvCfg
+	}
+	return cfg
+}
+
+// Config returns the current settings of the provider
+func (prv *CachedURLCertsProvider) Config() CertsProviderConfig {
+	cfg := CertsProviderConfig{
+		URL:                prv.certsURL(),
+		RefreshBefore:      prv.refreshBefore,
+		FetchTimeout:       prv.fetchTimeout,
+		StaleGracePeriod:   prv.staleGrace,
+		SynchronousRefresh: prv.synchronous,
+	}
+	if prv.discovery != nil {
+		cfg.DiscoveryURL = prv.discovery.url
+	}
+	return cfg
+}
@@ -0,0 +1,71 @@
+package GoogleIdTokenVerifier
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConfig(t *testing.T) {
+	verifier := New(NewStaticCertsProvider())
+	cfg := verifier.Config()
+	assert.Equal(t, []string{"accounts.google.com", "https://accounts.google.com"}, cfg.Issuers)
+	assert.Equal(t, AnyMatch, cfg.AudienceMatchMode)
+	assert.Zero(t, cfg.TrialVerificationMaxKeys)
+	assert.False(t, cfg.ReplayDetection)
+	assert.Equal(t, 30*time.Second, cfg.Leeway)
+	assert.Zero(t, cfg.VerifyTimeout)
+	assert.Nil(t, cfg.CertsProvider)
+
+	ts := httptest.NewServer(getHandlerFunc(http.StatusOK, time.Hour*2, nil))
+	defer ts.Close()
+	certProv, err := newCachedURLCertsProvider(ts.URL,
+		WithRefreshBefore(-10*time.Minute),
+		WithCertsFetchTimeout(3*time.Second),
+		WithStaleGracePeriod(time.Hour),
+		WithSynchronousRefresh())
+	require.NoError(t, err)
+
+	verifier = New(certProv,
+		WithAudienceMatchMode(ExactSetMatch),
+		WithTrialVerification(3),
+		WithLeeway(time.Minute),
+		WithVerifyTimeout(2*time.Second),
+		WithReplayDetector(&memoryReplayDetector{seen: map[string]time.Time{}}))
+	cfg = verifier.Config()
+	assert.Equal(t, ExactSetMatch, cfg.AudienceMatchMode)
+	assert.Equal(t, 3, cfg.TrialVerificationMaxKeys)
+	assert.True(t, cfg.ReplayDetection)
+	assert.Equal(t, time.Minute, cfg.Leeway)
+	assert.Equal(t, 2*time.Second, cfg.VerifyTimeout)
+	require.NotNil(t, cfg.CertsProvider)
+	assert.Equal(t, CertsProviderConfig{
+		URL:                ts.URL,
+		RefreshBefore:      -10 * time.Minute,
+		FetchTimeout:       3 * time.Second,
+		StaleGracePeriod:   time.Hour,
+		SynchronousRefresh: true,
+	}, *cfg.CertsProvider)
+
+	// providers using discovery report where the certs are downloaded from
+	mux := http.NewServeMux()
+	mux.Handle(openIDConfigurationPath, discoveryHandlerFunc("", "public, max-age=3600", func() string { return "/oauth2/v3/certs" }, nil))
+	mux.Handle("/oauth2/v3/certs", getTestCertsHandlerFunc(t, 2*time.Hour, nil))
+	discoveryServer := httptest.NewServer(mux)
+	defer discoveryServer.Close()
+	discoveryProv, err := NewDiscoveryCertsProvider(context.Background(), discoveryServer.URL)
+	require.NoError(t, err)
+	defer discoveryProv.Close()
+	prvCfg := discoveryProv.Config()
+	assert.Equal(t, discoveryServer.URL+"/oauth2/v3/certs", prvCfg.URL)
+	assert.Equal(t, discoveryServer.URL+openIDConfigurationPath, prvCfg.DiscoveryURL)
+
+	// the snapshot is read-only
+	cfg.Issuers[0] = "evil.com"
+	assert.Equal(t, "accounts.google.com", verifier.Config().Issuers[0])
+}
@@ -0,0 +1,118 @@
+package GoogleIdTokenVerifier
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// GoogleIssuerURL is the issuer of Google ID tokens, whose discovery document points to GoogleCertsURL
+const GoogleIssuerURL string = "https://accounts.google.com"
+
+// openIDConfigurationPath is where an issuer publishes its OpenID Connect discovery document
+const openIDConfigurationPath = "/.well-known/openid-configuration"
+
+// NewDiscoveryCertsProvider returns a provider of the certs of the OpenID Connect issuer at
+// issuerBaseURL, such as GoogleIssuerURL or FirebaseIssuer(projectID). The URL of the certs is the
+// jwks_uri of the discovery document of the issuer, which is downloaded with ctx and must be
+// published by the same issuer. The provider then behaves like the one of
+// NewCachedURLCertsProviderWithURL with opts, and downloads the discovery document again to
+// refresh expired certs once the document has expired too. When it cannot be downloaded then, the
+// last jwks_uri is kept. It fails if the discovery document cannot be downloaded or is not valid.
+func NewDiscoveryCertsProvider(ctx context.Context, issuerBaseURL string, opts ...CertsProviderOption) (*CachedURLCertsProvider, error) {
+	issuer := strings.TrimSuffix(issuerBaseURL, "/")
+	if err := checkHTTPURL(issuer); err != nil {
+		return nil, fmt.Errorf("invalid issuer URL %q: %v", issuerBaseURL, err)
+	}
+	discoveryURL := issuer + openIDConfigurationPath
+	prv := newUnloadedCertsProvider(discoveryURL, defaultRefreshBefore, opts...)
+	prv.discovery = &discovery{issuer: issuer, url: discoveryURL}
+	if err := prv.validate(); err != nil {
+		return nil, err
+	}
+	// unlike the certs, which can be retried later, the discovery document is required upfront
+	if _, _, err := prv.discovery.resolve(ctx, prv.client); err != nil {
+		return nil, err
+	}
+	prv.start()
+	return prv, nil
+}
+
+// discovery resolves the URL of the certs of an issuer from its discovery document
+type discovery struct {
+	issuer  string
+	url     string
+	mutex   sync.Mutex
+	jwksURI string
+	expires time.Time
+}
+
+// discoveryDocument holds the fields of an OpenID Connect discovery document used to find the certs
+type discoveryDocument struct {
+	Issuer  string `json:"issuer"`
+	JwksURI string `json:"jwks_uri"`
+}
+
+// resolve returns the URL of the certs, and whether it changed since the last call, downloading the
+// discovery document when it has expired. When the download fails, the last URL is returned, if
+// any, along with the error.
+func (d *discovery) resolve(ctx context.Context, client *http.Client) (string, bool, error) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	if d.jwksURI != "" && time.Now().Before(d.expires) {
+		return d.jwksURI, false, nil
+	}
+	jwksURI, expires, err := d.fetch(ctx, client)
+	if err != nil {
+		return d.jwksURI, false, err
+	}
+	changed := d.jwksURI != "" && d.jwksURI != jwksURI
+	d.jwksURI, d.expires = jwksURI, expires
+	return jwksURI, changed, nil
+}
+
+// lastJwksURI returns the last URL of the certs resolved, empty until the first resolution
+func (d *discovery) lastJwksURI() string {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	return d.jwksURI
+}
+
+// fetch downloads the discovery document and returns its jwks_uri along with when it expires
+func (d *discovery) fetch(ctx context.Context, client *http.Client) (string, time.Time, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", d.url, nil)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	res, err := client.Do(req)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		body, _ := ioutil.ReadAll(io.LimitReader(res.Body, maxCertsErrorBodyBytes))
+		return "", time.Time{}, &CertsFetchError{StatusCode: res.StatusCode, Body: string(body), URL: d.url}
+	}
+	data, err := readCertsBody(res.Body)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	var doc discoveryDocument
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return "", time.Time{}, fmt.Errorf("invalid discovery document at %s: %v", d.url, err)
+	}
+	if strings.TrimSuffix(doc.Issuer, "/") != d.issuer {
+		return "", time.Time{}, fmt.Errorf("invalid discovery document at %s: the issuer is %q, expected %q", d.url, doc.Issuer, d.issuer)
+	}
+	if err := checkHTTPURL(doc.JwksURI); err != nil {
+		return "", time.Time{}, fmt.Errorf("invalid discovery document at %s: jwks_uri %q: %v", d.url, doc.JwksURI, err)
+	}
+	return doc.JwksURI, certsExpiry(res.Header, time.Now()), nil
+}
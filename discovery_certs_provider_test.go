@@ -0,0 +1,129 @@
+package GoogleIdTokenVerifier
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// discoveryHandlerFunc serves the discovery document of issuer, the server itself when empty,
+// whose jwks_uri is the path returned by jwksPath on the server
+func discoveryHandlerFunc(issuer string, cacheControl string, jwksPath func() string, requestCount *int32) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		incrementAndGet(requestCount)
+		self := "http://" + r.Host
+		docIssuer := issuer
+		if docIssuer == "" {
+			docIssuer = self
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", cacheControl)
+		fmt.Fprintf(w, `{"issuer": %q, "jwks_uri": %q, "id_token_signing_alg_values_supported": ["RS256"]}`, docIssuer, self+jwksPath())
+	}
+}
+
+func TestDiscoveryCertsProvider(t *testing.T) {
+	var discoveryCount, certsCount int32
+	mux := http.NewServeMux()
+	mux.Handle(openIDConfigurationPath, discoveryHandlerFunc("", "public, max-age=3600", func() string { return "/oauth2/v3/certs" }, &discoveryCount))
+	mux.Handle("/oauth2/v3/certs", getTestCertsHandlerFunc(t, 2*time.Hour, &certsCount))
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	prv, err := NewDiscoveryCertsProvider(context.Background(), ts.URL+"/")
+	require.NoError(t, err)
+	defer prv.Close()
+	certs, err := prv.GetCerts()
+	require.NoError(t, err)
+	assert.Equal(t, loadTestCerts(t).Keys, certs.Keys)
+	assert.EqualValues(t, 1, atomic.LoadInt32(&discoveryCount))
+	assert.EqualValues(t, 1, atomic.LoadInt32(&certsCount))
+
+	_, err = New(prv).VerifyE(signTestToken(t, testClaims(time.Now())), testAud)
+	assert.NoError(t, err)
+}
+
+func TestDiscoveryCertsProviderErrors(t *testing.T) {
+	certsPath := func() string { return "/certs" }
+	tests := []struct {
+		testName string
+		handler  http.HandlerFunc
+		opts     []CertsProviderOption
+		expErr   string
+	}{
+		{"Other issuer", discoveryHandlerFunc("https://evil.com", "", certsPath, nil), nil, `the issuer is "https://evil.com"`},
+		{"Relative jwks_uri", func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprintf(w, `{"issuer": "http://%s", "jwks_uri": "/certs"}`, r.Host)
+		}, nil, `jwks_uri "/certs"`},
+		{"Not found", getHandlerFunc(http.StatusNotFound, 0, nil), nil, "Unsuccessful status code: 404"},
+		{"Not JSON", func(w http.ResponseWriter, r *http.Request) { _, _ = w.Write([]byte("<html>")) }, nil, "invalid discovery document"},
+		{"Offline", discoveryHandlerFunc("", "", certsPath, nil), []CertsProviderOption{WithOffline()}, "offline"},
+	}
+
+	for _, tc := range tests {
+		// nolint
+		t.Run(tc.testName, func(t *testing.T) {
+			ts := httptest.NewServer(tc.handler)
+			defer ts.Close()
+			prv, err := NewDiscoveryCertsProvider(context.Background(), ts.URL, tc.opts...)
+			assert.Nil(t, prv)
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tc.expErr)
+		})
+	}
+
+	_, err := NewDiscoveryCertsProvider(context.Background(), "accounts.google.com")
+	assert.Error(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = NewDiscoveryCertsProvider(ctx, GoogleIssuerURL)
+	assert.True(t, errors.Is(err, context.Canceled), "got %v", err)
+}
+
+func TestDiscoveryCertsProviderResolvesAgain(t *testing.T) {
+	var jwksPath atomic.Value
+	jwksPath.Store("/certs")
+	var discoveryDown int32
+	var certsCount, movedCertsCount int32
+	discovery := discoveryHandlerFunc("", "no-cache", func() string { return jwksPath.Load().(string) }, nil)
+	mux := http.NewServeMux()
+	mux.HandleFunc(openIDConfigurationPath, func(w http.ResponseWriter, r *http.Request) {
+		if atomic.LoadInt32(&discoveryDown) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		discovery(w, r)
+	})
+	// certs are expired as soon as they are served
+	mux.Handle("/certs", getTestCertsHandlerFunc(t, -time.Minute, &certsCount))
+	mux.Handle("/moved/certs", getTestCertsHandlerFunc(t, -time.Minute, &movedCertsCount))
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	prv, err := NewDiscoveryCertsProvider(context.Background(), ts.URL, WithSynchronousRefresh())
+	require.NoError(t, err)
+	defer prv.Close()
+	assert.EqualValues(t, 1, atomic.LoadInt32(&certsCount))
+
+	// the jwks_uri changes, expired certs are downloaded from the new one
+	jwksPath.Store("/moved/certs")
+	_, err = prv.GetCerts()
+	require.NoError(t, err)
+	assert.EqualValues(t, 1, atomic.LoadInt32(&certsCount))
+	assert.EqualValues(t, 1, atomic.LoadInt32(&movedCertsCount))
+
+	// without discovery document, the last jwks_uri is kept
+	atomic.StoreInt32(&discoveryDown, 1)
+	_, err = prv.GetCerts()
+	require.NoError(t, err)
+	assert.EqualValues(t, 2, atomic.LoadInt32(&movedCertsCount))
+}
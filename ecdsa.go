@@ -0,0 +1,67 @@
+package GoogleIdTokenVerifier
+
+import (
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"errors"
+	"fmt"
+	"math/big"
+)
+
+// es256CoordinateSize is the size of the coordinates of P-256 points, and of the r and s halves of ES256 signatures
+const es256CoordinateSize = 32
+
+var errECDSAVerification = errors.New("ecdsa: verification error")
+
+// ecKey is the EC public key of a Key, parsed once to be reused across verifications
+type ecKey struct {
+	jwk Key
+	pub *ecdsa.PublicKey
+	// err is why the key cannot verify any signature
+	err error
+}
+
+// parseECKey parses a P-256 key, the only curve of ES256
+func parseECKey(key Key) *ecKey {
+	k := &ecKey{jwk: key}
+	x, errX := urlsafeB64decode(key.X)
+	y, errY := urlsafeB64decode(key.Y)
+	if errX != nil || errY != nil || key.Crv != "P-256" || len(x) != es256CoordinateSize || len(y) != es256CoordinateSize {
+		k.err = fmt.Errorf("%w: invalid EC key", ErrSignatureInvalid)
+		return k
+	}
+	// the point must be on the curve
+	point := append(append([]byte{4}, x...), y...)
+	if _, err := ecdh.P256().NewPublicKey(point); err != nil {
+		k.err = fmt.Errorf("%w: invalid EC key: %v", ErrSignatureInvalid, err)
+		return k
+	}
+	k.pub = &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+	return k
+}
+
+func (k *ecKey) alg() string {
+	return "ES256"
+}
+
+func (k *ecKey) source() Key {
+	return k.jwk
+}
+
+// verify verifies the ES256 signature of a token whose SHA256 sum is hashed. Signatures must
+// be r || s as JWS requires, ASN.1 DER ones are refused so that a token has a single encoding.
+func (k *ecKey) verify(hashed []byte, signature []byte) error {
+	if k.err != nil {
+		return k.err
+	}
+	if len(signature) != 2*es256CoordinateSize {
+		return errECDSAVerification
+	}
+	r := new(big.Int).SetBytes(signature[:es256CoordinateSize])
+	s := new(big.Int).SetBytes(signature[es256CoordinateSize:])
+	if !ecdsa.Verify(k.pub, hashed, r, s) {
+		return errECDSAVerification
+	}
+	return nil
+}
@@ -0,0 +1,123 @@
+package GoogleIdTokenVerifier
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testECKeyID string = "ec-test-key"
+
+// rfc7515ES256Token is the ES256 example of RFC 7515, appendix A.3, signed by rfc7515ECKey
+const rfc7515ES256Token string = "eyJhbGciOiJFUzI1NiJ9" +
+	".eyJpc3MiOiJqb2UiLA0KICJleHAiOjEzMDA4MTkzODAsDQogImh0dHA6Ly9leGFtcGxlLmNvbS9pc19yb290Ijp0cnVlfQ" +
+	".DtEhU3ljbEg8L38VWAfUAqOyKAM6-Xx-F4GawxaepmXFCgfTjDxw5djxLa8ISlSApmWQxfKTUJqPP3-Kg6NU1Q"
+
+var rfc7515ECKey = Key{
+	Kty: "EC",
+	Crv: "P-256",
+	X:   "f83OJ3D2xF1Bg8vub9tLe1gHMzV76e8Tus9uPHvRVEU",
+	Y:   "x_FEzRu9m36HLN_tue659LNpXW6pCyStikYjKIWI5a0",
+}
+
+func TestES256TestVector(t *testing.T) {
+	token, err := Parse(rfc7515ES256Token)
+	require.NoError(t, err)
+	assert.NoError(t, token.VerifySignature(&Certs{Keys: []Key{rfc7515ECKey}}))
+
+	other := rfc7515ECKey
+	other.X, other.Y = other.Y, other.X
+	assert.True(t, errors.Is(token.VerifySignature(&Certs{Keys: []Key{other}}), ErrSignatureInvalid))
+}
+
+// signES256TestToken signs a token with priv, the signature is r || s unless der
+func signES256TestToken(t *testing.T, priv *ecdsa.PrivateKey, header map[string]interface{}, claims map[string]interface{}, der bool) string {
+	bHeader, err := json.Marshal(header)
+	require.NoError(t, err)
+	bClaims, err := json.Marshal(claims)
+	require.NoError(t, err)
+	messageToSign := base64.RawURLEncoding.EncodeToString(bHeader) + "." + base64.RawURLEncoding.EncodeToString(bClaims)
+	sum := sha256.Sum256([]byte(messageToSign))
+	var signature []byte
+	if der {
+		signature, err = ecdsa.SignASN1(rand.Reader, priv, sum[:])
+		require.NoError(t, err)
+	} else {
+		r, s, err := ecdsa.Sign(rand.Reader, priv, sum[:])
+		require.NoError(t, err)
+		signature = make([]byte, 2*es256CoordinateSize)
+		r.FillBytes(signature[:es256CoordinateSize])
+		s.FillBytes(signature[es256CoordinateSize:])
+	}
+	return messageToSign + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func TestES256(t *testing.T) {
+	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	ecJWK := Key{
+		Kty: "EC",
+		Alg: "ES256",
+		Use: "sig",
+		Kid: testECKeyID,
+		Crv: "P-256",
+		X:   base64.RawURLEncoding.EncodeToString(priv.X.FillBytes(make([]byte, es256CoordinateSize))),
+		Y:   base64.RawURLEncoding.EncodeToString(priv.Y.FillBytes(make([]byte, es256CoordinateSize))),
+	}
+	certs := loadTestCerts(t)
+	certs.Keys = append(certs.Keys, ecJWK)
+	verifier := New(&StaticCertsProvider{certs: parsedCerts(certs)})
+
+	es256Header := map[string]interface{}{"alg": "ES256", "kid": testECKeyID, "typ": "JWT"}
+	valid := signES256TestToken(t, priv, es256Header, testClaims(time.Now()), false)
+	otherSub := testClaims(time.Now())
+	otherSub["sub"] = "208426113748299532117"
+	tampered := strings.Split(signES256TestToken(t, priv, es256Header, otherSub, false), ".")
+	offCurve := ecJWK
+	offCurve.X = ecJWK.Y
+
+	tests := []struct {
+		testName string
+		verifier *GoogleTokenVerifier
+		token    string
+		expErr   error
+	}{
+		{"ES256 token", verifier, valid, nil},
+		{"ES256 token with a DER signature", verifier, signES256TestToken(t, priv, es256Header, testClaims(time.Now()), true), ErrSignatureInvalid},
+		{"Tampered ES256 token", verifier, tampered[0] + "." + strings.Split(valid, ".")[1] + "." + tampered[2], ErrSignatureInvalid},
+		{"ES256 token with the kid of an RSA key", verifier,
+			signES256TestToken(t, priv, map[string]interface{}{"alg": "ES256", "kid": testKeyID}, testClaims(time.Now()), false), ErrUnsupportedAlgorithm},
+		{"RS256 token with the kid of an EC key", verifier,
+			signTestTokenWithHeader(t, map[string]interface{}{"alg": "RS256", "kid": testECKeyID}, testClaims(time.Now())), ErrUnsupportedAlgorithm},
+		{"EC key off the curve", New(&StaticCertsProvider{certs: &Certs{Keys: []Key{offCurve}}}), valid, ErrSignatureInvalid},
+		{"RS256 token", verifier, signTestToken(t, testClaims(time.Now())), nil},
+	}
+
+	for _, tc := range tests {
+		// nolint
+		t.Run(tc.testName, func(t *testing.T) {
+			tokeninfo, err := tc.verifier.VerifyE(tc.token, testAud)
+			if tc.expErr != nil {
+				assert.Nil(t, tokeninfo)
+				assert.True(t, errors.Is(err, tc.expErr), "got %v", err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, "110169484474386276334", tokeninfo.Sub)
+		})
+	}
+
+	tokeninfo, err := verifier.VerifyWithJWK(valid, testAud, ecJWK)
+	require.NoError(t, err)
+	assert.NotNil(t, tokeninfo)
+}
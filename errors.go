@@ -0,0 +1,121 @@
+package GoogleIdTokenVerifier
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrCertsUnavailable matches, with errors.Is, the errors of verifications that failed because
+// the certs could not be retrieved, a server-side failure rather than a bad token
+var ErrCertsUnavailable = errors.New("Token could not be verified, certs are unavailable")
+
+// ErrVerifyTimeout is returned when the certs could not be retrieved within the verify timeout
+var ErrVerifyTimeout = errors.New("Token could not be verified, verify timeout exceeded")
+
+// Errors of each validation failure, wrapped in the errors returned by the verifier so they
+// can be matched with errors.Is
+var (
+	// ErrMalformedToken is a token that cannot be decoded
+	ErrMalformedToken = errors.New("Token is not valid, malformed token")
+	// ErrClaimTooLarge is a token with a string claim larger than allowed, see WithMaxClaimValueBytes
+	ErrClaimTooLarge = errors.New("Token is not valid, claim is too large")
+	// ErrKeyIDNotFound is a token whose kid is not in the certs
+	ErrKeyIDNotFound = errors.New("Token is not valid, kid from token and certificate don't match")
+	// ErrUnsupportedAlgorithm is a token whose header alg is neither RS256 nor ES256, or is not
+	// the algorithm of the key of its kid
+	ErrUnsupportedAlgorithm = errors.New("Token is not valid, alg is not supported")
+	// ErrHeaderTypeMismatch is a token whose header typ is not the one required, see WithStrictHeaderType
+	ErrHeaderTypeMismatch = errors.New("Token is not valid, typ is not the one required")
+	// ErrSignatureInvalid is a token whose signature does not match its key
+	ErrSignatureInvalid = errors.New("Token is not valid, signature is invalid")
+	// ErrAudienceMismatch is a token issued for another audience
+	ErrAudienceMismatch = errors.New("Token is not valid, Audience from token and certificate don't match")
+	// ErrClientIDMismatch is a token whose aud and azp are not known client IDs, see WithStrictClientCheck
+	ErrClientIDMismatch = errors.New("Token is not valid, neither aud nor azp are accepted client IDs")
+	// ErrAuthorizedPartyMismatch is a token whose azp is not the one required, see WithAuthorizedParty
+	ErrAuthorizedPartyMismatch = errors.New("Token is not valid, azp is not the authorized party required")
+	// ErrIssuerMismatch is a token issued by an issuer that is not accepted
+	ErrIssuerMismatch = errors.New("Token is not valid, ISS from token and certificate don't match")
+	// ErrTokenExpired is a token used after its exp
+	ErrTokenExpired = errors.New("Token is not valid, Token is expired")
+	// ErrTokenNotYetValid is a token used before its iat
+	ErrTokenNotYetValid = errors.New("Token is not valid, Token is not valid yet")
+	// ErrTokenTooOld is a token issued longer ago than allowed, see WithMaxAge
+	ErrTokenTooOld = errors.New("Token is not valid, Token is too old")
+	// ErrMissingSubject is a token without sub, the identifier of the user
+	ErrMissingSubject = errors.New("Token is not valid, sub is missing")
+	// ErrInvalidSubjectFormat is a token whose sub is not numeric, see WithNumericSubject
+	ErrInvalidSubjectFormat = errors.New("Token is not valid, sub is not numeric")
+	// ErrNonceMismatch is a token without the nonce expected, see VerifyWithNonce
+	ErrNonceMismatch = errors.New("Token is not valid, nonce does not match")
+	// ErrHostedDomainMismatch is a token whose hd is not the required one, see WithHostedDomain
+	ErrHostedDomainMismatch = errors.New("Token is not valid, hd is not the hosted domain required")
+	// ErrEmailDomainBlocked is a token whose verified email is in a blocked domain, see WithBlockedEmailDomains
+	ErrEmailDomainBlocked = errors.New("Token is not valid, email domain is blocked")
+	// ErrEmailNotVerified is a token with an email that is not verified, see WithRequireVerifiedEmail
+	ErrEmailNotVerified = errors.New("Token is not valid, email is not verified")
+	// ErrMissingJti is a token without jti while replays are detected, see WithReplayDetector
+	ErrMissingJti = errors.New("Token is not valid, jti is required for replay detection")
+	// ErrTokenReplayed is a token whose jti has already been seen, see WithReplayDetector
+	ErrTokenReplayed = errors.New("Token is not valid, Token has already been used")
+)
+
+// ErrorKind is the category of a verification failure
+type ErrorKind int
+
+const (
+	// MalformedToken is a token that cannot be decoded
+	MalformedToken ErrorKind = iota + 1
+	// InvalidSignature is a token whose signature cannot be verified with the certs
+	InvalidSignature
+	// InvalidClaims is a token with a valid signature but whose claims are rejected
+	InvalidClaims
+	// CertsUnavailable is a verification that failed because the certs could not be retrieved
+	CertsUnavailable
+)
+
+func (k ErrorKind) String() string {
+	switch k {
+	case MalformedToken:
+		return "malformed token"
+	case InvalidSignature:
+		return "invalid signature"
+	case InvalidClaims:
+		return "invalid claims"
+	case CertsUnavailable:
+		return "certs unavailable"
+	default:
+		return "unknown"
+	}
+}
+
+// VerifyError is the error returned when a token cannot be verified
+type VerifyError struct {
+	Kind ErrorKind
+	Err  error
+}
+
+func newVerifyError(kind ErrorKind, err error) *VerifyError {
+	return &VerifyError{Kind: kind, Err: err}
+}
+
+func (e *VerifyError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *VerifyError) Unwrap() error {
+	return e.Err
+}
+
+// Is makes the errors of kind CertsUnavailable match ErrCertsUnavailable
+func (e *VerifyError) Is(target error) bool {
+	return target == ErrCertsUnavailable && e.Kind == CertsUnavailable
+}
+
+// wrapSentinel makes err match sentinel with errors.Is, unless it already does
+func wrapSentinel(sentinel error, err error) error {
+	if errors.Is(err, sentinel) {
+		return err
+	}
+	return fmt.Errorf("%w: %v", sentinel, err)
+}
@@ -0,0 +1,31 @@
+package GoogleIdTokenVerifier
+
+import (
+	"context"
+	"fmt"
+)
+
+// authenticateMetadata verifies for aud the bearer token of the "authorization" metadata of
+// a gRPC call, md being its metadata.MD, and returns ctx with the token, see FromContext.
+// It is kept out of the grpc build tag, so that it is tested without gRPC.
+// Calls without exactly one bearer token fail with ErrNoBearerToken, the others like
+// VerifyContext does.
+func (v *GoogleTokenVerifier) authenticateMetadata(ctx context.Context, md map[string][]string, aud string) (context.Context, error) {
+	values := md["authorization"]
+	if len(values) > 1 {
+		return ctx, fmt.Errorf("%w: there are %d authorization values", ErrNoBearerToken, len(values))
+	}
+	var header string
+	if len(values) == 1 {
+		header = values[0]
+	}
+	authToken, err := ExtractBearerToken(header)
+	if err != nil {
+		return ctx, err
+	}
+	tokeninfo, err := v.VerifyContext(ctx, authToken, aud)
+	if err != nil {
+		return ctx, err
+	}
+	return context.WithValue(ctx, TokenInfoContextKey, tokeninfo), nil
+}
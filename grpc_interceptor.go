@@ -0,0 +1,32 @@
+//go:build grpc
+
+package GoogleIdTokenVerifier
+
+import (
+	"context"
+	"errors"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// UnaryServerInterceptor returns a gRPC interceptor verifying for aud the bearer token of the
+// "authorization" metadata of every unary call. Calls without a valid token fail with
+// codes.Unauthenticated, and with codes.Unavailable when the certs are unavailable. The
+// handlers get the token with FromContext.
+// It is only built with the grpc build tag, so that the users not using gRPC do not depend on it.
+func (v *GoogleTokenVerifier) UnaryServerInterceptor(aud string) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		md, _ := metadata.FromIncomingContext(ctx)
+		ctx, err := v.authenticateMetadata(ctx, md, aud)
+		if errors.Is(err, ErrCertsUnavailable) {
+			return nil, status.Error(codes.Unavailable, "the certs to verify the token are unavailable")
+		}
+		if err != nil {
+			return nil, status.Error(codes.Unauthenticated, "the token could not be verified")
+		}
+		return handler(ctx, req)
+	}
+}
@@ -0,0 +1,42 @@
+package GoogleIdTokenVerifier
+
+import "golang.org/x/text/language"
+
+// LocaleTag parses the "locale" claim of the token, a BCP 47 language tag.
+// A token without locale returns language.Und and no error.
+func (t *TokenInfo) LocaleTag() (language.Tag, error) {
+	if t.Local == "" {
+		return language.Und, nil
+	}
+	return language.Parse(t.Local)
+}
+
+// familyNameFirst are the languages writing the family name before the given name,
+// mapped to the separator between both names
+var familyNameFirst = map[language.Base]string{
+	language.MustParseBase("ja"): "",
+	language.MustParseBase("zh"): "",
+	language.MustParseBase("ko"): "",
+	language.MustParseBase("hu"): " ",
+	language.MustParseBase("vi"): " ",
+}
+
+// DisplayName returns the name of the user, or when the token has no name claim, the given and
+// family names joined in the order of the locale of the token. Locales writing the family name
+// first are Japanese, Chinese, Korean, Hungarian and Vietnamese; any other locale, or a token
+// without a valid locale, joins them as "given family" separated by a space.
+func (t *TokenInfo) DisplayName() string {
+	if t.Name != "" {
+		return t.Name
+	}
+	if t.GivenName == "" || t.FamilyName == "" {
+		return t.GivenName + t.FamilyName
+	}
+	if tag, err := t.LocaleTag(); err == nil {
+		base, _ := tag.Base()
+		if separator, ok := familyNameFirst[base]; ok {
+			return t.FamilyName + separator + t.GivenName
+		}
+	}
+	return t.GivenName + " " + t.FamilyName
+}
@@ -0,0 +1,12 @@
+package GoogleIdTokenVerifier
+
+// Logger receives the errors of the verifier and the certs provider, see WithLogger and
+// WithCertsLogger. Nothing is logged by default.
+type Logger interface {
+	Errorf(format string, args ...interface{})
+}
+
+// nopLogger is the default Logger, it discards everything
+type nopLogger struct{}
+
+func (nopLogger) Errorf(format string, args ...interface{}) {}
@@ -0,0 +1,156 @@
+package GoogleIdTokenVerifier
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// recordingLogger keeps the lines logged
+type recordingLogger struct {
+	mutex sync.Mutex
+	lines []string
+}
+
+func (l *recordingLogger) Errorf(format string, args ...interface{}) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.lines = append(l.lines, fmt.Sprintf(format, args...))
+}
+
+func (l *recordingLogger) logged() []string {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return append([]string(nil), l.lines...)
+}
+
+func TestVerifierLogger(t *testing.T) {
+	logger := &recordingLogger{}
+	verifier := New(&StaticCertsProvider{certs: loadTestCerts(t)}, WithLogger(logger))
+	assert.NotNil(t, verifier.Verify(signTestToken(t, testClaims(time.Now())), testAud))
+	assert.Empty(t, logger.logged())
+
+	assert.Nil(t, verifier.Verify("XXXXXXXXXXX.XXXXXXXXXXXX.XXXXXXXXXX", testAud))
+	require.Len(t, logger.logged(), 1)
+	assert.Contains(t, logger.logged()[0], "Error verifying key")
+	assert.Contains(t, logger.logged()[0], "malformed token")
+
+	// a nil logger disables logging
+	assert.Nil(t, New(&StaticCertsProvider{certs: loadTestCerts(t)}, WithLogger(nil)).Verify("abc", testAud))
+}
+
+func TestCertsLogger(t *testing.T) {
+	ts := httptest.NewServer(getHandlerFunc(http.StatusInternalServerError, 0, nil))
+	defer ts.Close()
+
+	logger := &recordingLogger{}
+	certProv, err := newCachedURLCertsProvider(ts.URL, WithCertsLogger(logger))
+	require.NoError(t, err)
+	_, err = certProv.GetCerts()
+	assert.Error(t, err)
+	require.NotEmpty(t, logger.logged())
+	assert.Equal(t, fmt.Sprintf("[GoogleTokenVerifier] ERROR loading certs from %s: Unsuccessful status code: 500", ts.URL), logger.logged()[0])
+
+	certProv, err = newCachedURLCertsProvider(ts.URL, WithCertsLogger(nil))
+	require.NoError(t, err)
+	_, err = certProv.GetCerts()
+	assert.Error(t, err)
+}
+
+// newTestSlogLogger returns a logger writing JSON records to the returned buffer
+func newTestSlogLogger() (*slog.Logger, *syncBuffer) {
+	buf := &syncBuffer{}
+	return slog.New(slog.NewJSONHandler(buf, nil)), buf
+}
+
+// syncBuffer is a bytes.Buffer safe for concurrent use
+type syncBuffer struct {
+	mutex sync.Mutex
+	buf   bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.buf.Write(p)
+}
+
+// records decodes the JSON records written
+func (b *syncBuffer) records(t *testing.T) []map[string]interface{} {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	var records []map[string]interface{}
+	for _, line := range strings.Split(strings.TrimSpace(b.buf.String()), "\n") {
+		if line == "" {
+			continue
+		}
+		var record map[string]interface{}
+		require.NoError(t, json.Unmarshal([]byte(line), &record))
+		records = append(records, record)
+	}
+	return records
+}
+
+func TestVerifierSlogLogger(t *testing.T) {
+	logger, buf := newTestSlogLogger()
+	verifier := New(&StaticCertsProvider{certs: loadTestCerts(t)}, WithSlogLogger(logger))
+	assert.NotNil(t, verifier.Verify(signTestToken(t, testClaims(time.Now())), testAud))
+	assert.Empty(t, buf.records(t))
+
+	assert.Nil(t, verifier.Verify(signTestToken(t, testClaims(time.Now())), "other.apps.googleusercontent.com"))
+	records := buf.records(t)
+	require.Len(t, records, 1)
+	assert.Equal(t, "ERROR", records[0]["level"])
+	assert.Equal(t, "token verification failed", records[0]["msg"])
+	assert.Equal(t, testKeyID, records[0]["kid"])
+	assert.Equal(t, "https://accounts.google.com", records[0]["iss"])
+	assert.Equal(t, testAud, records[0]["aud"])
+	assert.Equal(t, "invalid claims", records[0]["kind"])
+	assert.Contains(t, records[0]["reason"], "Audience")
+
+	// malformed tokens are logged without what cannot be decoded
+	assert.Nil(t, verifier.Verify("abc", testAud))
+	records = buf.records(t)
+	require.Len(t, records, 2)
+	assert.Equal(t, "malformed token", records[1]["kind"])
+	assert.NotContains(t, records[1], "kid")
+
+	// a nil logger disables logging
+	assert.Nil(t, New(&StaticCertsProvider{certs: loadTestCerts(t)}, WithSlogLogger(nil)).Verify("abc", testAud))
+}
+
+func TestCertsSlogLogger(t *testing.T) {
+	ts := httptest.NewServer(getHandlerFunc(http.StatusInternalServerError, 0, nil))
+	defer ts.Close()
+
+	logger, buf := newTestSlogLogger()
+	certProv, err := newCachedURLCertsProvider(ts.URL, WithCertsSlogLogger(logger))
+	require.NoError(t, err)
+	_, err = certProv.GetCerts()
+	assert.Error(t, err)
+	records := buf.records(t)
+	require.NotEmpty(t, records)
+	assert.Equal(t, "loading certs failed", records[0]["msg"])
+	assert.Equal(t, ts.URL, records[0]["url"])
+	assert.Equal(t, float64(http.StatusInternalServerError), records[0]["status"])
+
+	// without response there is no status
+	ts.Close()
+	logger, buf = newTestSlogLogger()
+	certProv, err = newCachedURLCertsProvider(ts.URL, WithCertsSlogLogger(logger))
+	require.NoError(t, err)
+	records = buf.records(t)
+	require.NotEmpty(t, records)
+	assert.Equal(t, ts.URL, records[0]["url"])
+	assert.NotContains(t, records[0], "status")
+}
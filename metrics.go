@@ -0,0 +1,54 @@
+package GoogleIdTokenVerifier
+
+import (
+	"errors"
+	"time"
+)
+
+// Metrics receives the outcome of every verification and certs refresh, to feed metrics such
+// as Prometheus counters and histograms, see WithMetrics and WithCertsMetrics. Its methods are
+// called synchronously and concurrently, they must be fast and safe for concurrent use.
+type Metrics interface {
+	// OnVerify is called after each verification with one of the VerifyResultXxx constants
+	OnVerify(result string, dur time.Duration)
+	// OnCertRefresh is called after each certs refresh, retries included. fromCache tells
+	// the certs had not changed and the cached ones were kept.
+	OnCertRefresh(success bool, dur time.Duration, fromCache bool)
+}
+
+// Results of the verifications given to Metrics.OnVerify
+const (
+	VerifyResultValid            = "valid"
+	VerifyResultExpired          = "expired"
+	VerifyResultMalformedToken   = "malformed_token"
+	VerifyResultInvalidSignature = "invalid_signature"
+	VerifyResultInvalidClaims    = "invalid_claims"
+	VerifyResultCertsUnavailable = "certs_unavailable"
+	VerifyResultError            = "error"
+)
+
+// verifyResult classifies the error of a verification, expired tokens apart from other claims
+func verifyResult(err error) string {
+	if err == nil {
+		return VerifyResultValid
+	}
+	if errors.Is(err, ErrTokenExpired) {
+		return VerifyResultExpired
+	}
+	var verr *VerifyError
+	if !errors.As(err, &verr) {
+		return VerifyResultError
+	}
+	switch verr.Kind {
+	case MalformedToken:
+		return VerifyResultMalformedToken
+	case InvalidSignature:
+		return VerifyResultInvalidSignature
+	case InvalidClaims:
+		return VerifyResultInvalidClaims
+	case CertsUnavailable:
+		return VerifyResultCertsUnavailable
+	default:
+		return VerifyResultError
+	}
+}
@@ -0,0 +1,108 @@
+package GoogleIdTokenVerifier
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// certRefresh is a call to Metrics.OnCertRefresh
+type certRefresh struct {
+	success   bool
+	fromCache bool
+}
+
+// countingMetrics counts verifications by result, as Prometheus counters would
+type countingMetrics struct {
+	mutex     sync.Mutex
+	results   map[string]int
+	refreshes []certRefresh
+}
+
+func (m *countingMetrics) OnVerify(result string, dur time.Duration) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.results[result]++
+}
+
+func (m *countingMetrics) OnCertRefresh(success bool, dur time.Duration, fromCache bool) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.refreshes = append(m.refreshes, certRefresh{success, fromCache})
+}
+
+func TestVerifyMetrics(t *testing.T) {
+	metrics := &countingMetrics{results: map[string]int{}}
+	verifier := New(&StaticCertsProvider{certs: loadTestCerts(t)}, WithMetrics(metrics))
+
+	valid := signTestToken(t, testClaims(time.Now()))
+	expired := signTestToken(t, testClaims(time.Now().Add(-2*time.Hour)))
+	otherSub := testClaims(time.Now())
+	otherSub["sub"] = "208426113748299532117"
+	tampered := strings.Split(signTestToken(t, otherSub), ".")
+	forged := tampered[0] + "." + strings.Split(valid, ".")[1] + "." + tampered[2]
+
+	for _, authToken := range []string{valid, valid, expired, forged, valid, "malformed"} {
+		verifier.Verify(authToken, testAud)
+	}
+	assert.Equal(t, map[string]int{
+		VerifyResultValid:            3,
+		VerifyResultExpired:          1,
+		VerifyResultInvalidSignature: 1,
+		VerifyResultMalformedToken:   1,
+	}, metrics.results)
+
+	_, err := New(failingCertsProvider{}, WithMetrics(metrics)).VerifyE(valid, testAud)
+	require.Error(t, err)
+	assert.Equal(t, 1, metrics.results[VerifyResultCertsUnavailable])
+
+	// every verification path is counted
+	verifier.VerifyToMap([]string{valid, expired}, testAud)
+	verifier.VerifyAll(forged, testAud)
+	assert.Equal(t, 4, metrics.results[VerifyResultValid])
+	assert.Equal(t, 2, metrics.results[VerifyResultExpired])
+	assert.Equal(t, 2, metrics.results[VerifyResultInvalidSignature])
+}
+
+func TestCertRefreshMetrics(t *testing.T) {
+	bCerts, err := ioutil.ReadFile(testCertsPath)
+	require.NoError(t, err)
+	var failing int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.LoadInt32(&failing) == 1 {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("ETag", `"v1"`)
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		_, _ = w.Write(bCerts)
+	}))
+	defer ts.Close()
+
+	metrics := &countingMetrics{results: map[string]int{}}
+	certProv, err := newCachedURLCertsProvider(ts.URL, WithCertsMetrics(metrics), WithSynchronousRefresh())
+	require.NoError(t, err)
+	certProv.expireForTest()
+	_, err = certProv.GetCerts()
+	require.NoError(t, err)
+	atomic.StoreInt32(&failing, 1)
+	certProv.expireForTest()
+	_, _ = certProv.GetCerts()
+
+	assert.Equal(t, []certRefresh{
+		{success: true, fromCache: false},
+		{success: true, fromCache: true},
+		{success: false, fromCache: false},
+	}, metrics.refreshes)
+}
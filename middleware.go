@@ -0,0 +1,80 @@
+package GoogleIdTokenVerifier
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// ErrNoBearerToken is returned by ExtractBearerToken for headers without a bearer token
+var ErrNoBearerToken = errors.New("Authorization header has no bearer token")
+
+// ExtractBearerToken returns the token of an Authorization header with the Bearer scheme,
+// whose name is case-insensitive. It fails with ErrNoBearerToken for empty headers, other
+// schemes or a Bearer scheme without token.
+func ExtractBearerToken(header string) (string, error) {
+	header = strings.TrimSpace(header)
+	if header == "" {
+		return "", fmt.Errorf("%w: the header is empty", ErrNoBearerToken)
+	}
+	scheme, authToken, _ := strings.Cut(header, " ")
+	if !strings.EqualFold(scheme, "Bearer") {
+		return "", fmt.Errorf("%w: the scheme is %q", ErrNoBearerToken, scheme)
+	}
+	authToken = strings.TrimSpace(authToken)
+	if authToken == "" {
+		return "", fmt.Errorf("%w: the token is empty", ErrNoBearerToken)
+	}
+	return authToken, nil
+}
+
+type contextKey struct{}
+
+// TokenInfoContextKey is the key of the *TokenInfo in the context of the requests verified by Middleware
+// or UnaryServerInterceptor
+var TokenInfoContextKey = contextKey{}
+
+// FromContext returns the token of a request verified by Middleware or UnaryServerInterceptor
+func FromContext(ctx context.Context) (*TokenInfo, bool) {
+	tokeninfo, ok := ctx.Value(TokenInfoContextKey).(*TokenInfo)
+	return tokeninfo, ok && tokeninfo != nil
+}
+
+// Middleware returns an HTTP middleware verifying the bearer token of the Authorization header
+// of each request for aud. Requests with a valid token reach the next handler with the token in
+// their context, see FromContext. The others are answered with 401 Unauthorized and a
+// WWW-Authenticate header, or 503 Service Unavailable when the certs cannot be retrieved.
+func (v *GoogleTokenVerifier) Middleware(aud string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			header := r.Header.Get("Authorization")
+			if header == "" {
+				unauthorized(w, `Bearer`)
+				return
+			}
+			authToken, err := ExtractBearerToken(header)
+			if err != nil {
+				unauthorized(w, `Bearer error="invalid_request", error_description="the Authorization header is not a bearer token"`)
+				return
+			}
+
+			tokeninfo, err := v.VerifyContext(r.Context(), authToken, aud)
+			if err != nil {
+				if errors.Is(err, ErrCertsUnavailable) {
+					http.Error(w, http.StatusText(http.StatusServiceUnavailable), http.StatusServiceUnavailable)
+					return
+				}
+				unauthorized(w, `Bearer error="invalid_token", error_description="the token could not be verified"`)
+				return
+			}
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), TokenInfoContextKey, tokeninfo)))
+		})
+	}
+}
+
+func unauthorized(w http.ResponseWriter, challenge string) {
+	w.Header().Set("WWW-Authenticate", challenge)
+	http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+}
@@ -0,0 +1,98 @@
+package GoogleIdTokenVerifier
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMiddleware(t *testing.T) {
+	verifier := New(&StaticCertsProvider{certs: loadTestCerts(t)})
+	authToken := signTestToken(t, testClaims(time.Now()))
+	var gotSub string
+	handler := verifier.Middleware(testAud)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tokeninfo, ok := FromContext(r.Context())
+		assert.True(t, ok)
+		gotSub = tokeninfo.Sub
+	}))
+
+	tests := []struct {
+		testName      string
+		handler       http.Handler
+		authorization string
+		expStatus     int
+		expChallenge  string
+	}{
+		{"Valid token", handler, "Bearer " + authToken, http.StatusOK, ""},
+		{"Lower case scheme", handler, "bearer " + authToken, http.StatusOK, ""},
+		{"Missing header", handler, "", http.StatusUnauthorized, `Bearer`},
+		{"Other scheme", handler, "Basic dXNlcjpwYXNz", http.StatusUnauthorized, `Bearer error="invalid_request", error_description="the Authorization header is not a bearer token"`},
+		{"Bearer without token", handler, "Bearer ", http.StatusUnauthorized, `Bearer error="invalid_request", error_description="the Authorization header is not a bearer token"`},
+		{"Token without scheme", handler, authToken, http.StatusUnauthorized, `Bearer error="invalid_request", error_description="the Authorization header is not a bearer token"`},
+		{"Malformed token", handler, "Bearer XXXXXXXXXXX.XXXXXXXXXXXX.XXXXXXXXXX", http.StatusUnauthorized, `Bearer error="invalid_token", error_description="the token could not be verified"`},
+		{"Certs unavailable", New(failingCertsProvider{}).Middleware(testAud)(handler), "Bearer " + authToken, http.StatusServiceUnavailable, ""},
+	}
+
+	for _, tc := range tests {
+		// nolint
+		t.Run(tc.testName, func(t *testing.T) {
+			gotSub = ""
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tc.authorization != "" {
+				req.Header.Set("Authorization", tc.authorization)
+			}
+			rec := httptest.NewRecorder()
+			tc.handler.ServeHTTP(rec, req)
+			assert.Equal(t, tc.expStatus, rec.Code)
+			assert.Equal(t, tc.expChallenge, rec.Header().Get("WWW-Authenticate"))
+			if tc.expStatus == http.StatusOK {
+				assert.Equal(t, "110169484474386276334", gotSub)
+			} else {
+				assert.Empty(t, gotSub)
+			}
+		})
+	}
+
+	_, ok := FromContext(context.Background())
+	assert.False(t, ok)
+}
+
+func TestExtractBearerToken(t *testing.T) {
+	tests := []struct {
+		testName string
+		header   string
+		expToken string
+		expError bool
+	}{
+		{"Bearer", "Bearer abc.def.ghi", "abc.def.ghi", false},
+		{"Lower case bearer", "bearer abc.def.ghi", "abc.def.ghi", false},
+		{"Upper case bearer", "BEARER abc.def.ghi", "abc.def.ghi", false},
+		{"Extra spaces", "  Bearer   abc.def.ghi ", "abc.def.ghi", false},
+		{"Empty header", "", "", true},
+		{"Blank header", "   ", "", true},
+		{"Basic scheme", "Basic dXNlcjpwYXNz", "", true},
+		{"Bearer without token", "Bearer", "", true},
+		{"Bearer with blank token", "Bearer   ", "", true},
+		{"Token without scheme", "abc.def.ghi", "", true},
+		{"Bearer prefix of other scheme", "Bearerabc.def.ghi", "", true},
+	}
+
+	for _, tc := range tests {
+		// nolint
+		t.Run(tc.testName, func(t *testing.T) {
+			authToken, err := ExtractBearerToken(tc.header)
+			if tc.expError {
+				assert.True(t, errors.Is(err, ErrNoBearerToken), "got %v", err)
+				assert.Empty(t, authToken)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expToken, authToken)
+		})
+	}
+}
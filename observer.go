@@ -0,0 +1,59 @@
+package GoogleIdTokenVerifier
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Observer is notified of noteworthy events during verification
+type Observer interface {
+	// OnAnomaly is called when a token is suspicious even if it may be valid
+	OnAnomaly(anomaly Anomaly)
+}
+
+// Anomaly describes something suspicious about a token
+type Anomaly struct {
+	Reason    string
+	TokenInfo *TokenInfo
+}
+
+// ErrAnomaly is returned when a token has an anomaly and the verifier rejects them
+var ErrAnomaly = errors.New("Token is not valid, anomaly detected")
+
+// checkIatSkew flags tokens issued too far away from the time the certs verifying them were fetched
+func (v *GoogleTokenVerifier) checkIatSkew(tokeninfo *TokenInfo) error {
+	if v.maxIatSkew <= 0 {
+		return nil
+	}
+	prv, ok := v.certProvider.(*CachedURLCertsProvider)
+	if !ok {
+		return nil
+	}
+	fetchedAt := prv.certsFetchedAt()
+	if fetchedAt.IsZero() {
+		return nil
+	}
+	skew := time.Unix(tokeninfo.Iat, 0).Sub(fetchedAt)
+	if skew < 0 {
+		skew = -skew
+	}
+	if skew <= v.maxIatSkew {
+		return nil
+	}
+	return v.flagAnomaly(Anomaly{
+		Reason:    fmt.Sprintf("iat is %v away from the certs fetch time, more than %v", skew.Round(time.Second), v.maxIatSkew),
+		TokenInfo: tokeninfo,
+	})
+}
+
+// flagAnomaly notifies the observer, and fails if anomalies are rejected
+func (v *GoogleTokenVerifier) flagAnomaly(anomaly Anomaly) error {
+	if v.observer != nil {
+		v.observer.OnAnomaly(anomaly)
+	}
+	if v.rejectAnomalies {
+		return newVerifyError(InvalidClaims, fmt.Errorf("%w: %s", ErrAnomaly, anomaly.Reason))
+	}
+	return nil
+}
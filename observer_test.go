@@ -0,0 +1,69 @@
+package GoogleIdTokenVerifier
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// recordingObserver keeps every event it is notified of
+type recordingObserver struct {
+	mutex     sync.Mutex
+	anomalies []Anomaly
+}
+
+func (o *recordingObserver) OnAnomaly(anomaly Anomaly) {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	o.anomalies = append(o.anomalies, anomaly)
+}
+
+func TestIatCertsSkewAnomaly(t *testing.T) {
+	certs := loadTestCerts(t)
+	certProv, err := NewCachedURLCertsProviderWithOptions(WithCertsFetcher(func(ctx context.Context) (*Certs, time.Time, error) {
+		return certs, time.Now().Add(2 * time.Hour), nil
+	}))
+	require.NoError(t, err)
+	freshToken := signTestToken(t, testClaims(time.Now()))
+	// still valid, but issued long before the certs were fetched
+	oldToken := signTestToken(t, testClaims(time.Now().Add(-50*time.Minute)))
+
+	observer := &recordingObserver{}
+	verifier := New(certProv, WithObserver(observer), WithIatCertsSkewCheck(10*time.Minute))
+	assert.NotNil(t, verifier.Verify(freshToken, testAud))
+	assert.Empty(t, observer.anomalies)
+
+	// the anomaly is not fatal by default
+	tokeninfo := verifier.Verify(oldToken, testAud)
+	assert.NotNil(t, tokeninfo)
+	require.Len(t, observer.anomalies, 1)
+	assert.Contains(t, observer.anomalies[0].Reason, "iat")
+	assert.Equal(t, tokeninfo, observer.anomalies[0].TokenInfo)
+
+	verifier = New(certProv, WithObserver(observer), WithIatCertsSkewCheck(10*time.Minute), WithRejectAnomalies())
+	tokeninfo, err = verifier.VerifyContext(context.Background(), oldToken, testAud)
+	assert.Nil(t, tokeninfo)
+	assert.True(t, errors.Is(err, ErrAnomaly))
+	assert.Len(t, observer.anomalies, 2)
+
+	// on every verification path
+	verified, errs := verifier.VerifyToMap([]string{oldToken}, testAud)
+	assert.Empty(t, verified)
+	require.Len(t, errs, 1)
+	assert.True(t, errors.Is(errs[0], ErrAnomaly))
+	tokeninfo, errs = verifier.VerifyAll(oldToken, testAud)
+	assert.Nil(t, tokeninfo)
+	require.Len(t, errs, 1)
+	assert.True(t, errors.Is(errs[0], ErrAnomaly))
+	assert.Len(t, observer.anomalies, 4)
+
+	// the check is opt-in
+	verifier = New(certProv, WithObserver(observer))
+	assert.NotNil(t, verifier.Verify(oldToken, testAud))
+	assert.Len(t, observer.anomalies, 4)
+}
@@ -0,0 +1,273 @@
+package GoogleIdTokenVerifier
+
+import (
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Option configures a GoogleTokenVerifier
+type Option func(*GoogleTokenVerifier)
+
+// WithAudienceMatchMode sets how the audiences of a token are matched against
+// the expected ones. AnyMatch is the default.
+func WithAudienceMatchMode(mode AudienceMatchMode) Option {
+	return func(v *GoogleTokenVerifier) {
+		v.audienceMatchMode = mode
+	}
+}
+
+// WithReplayDetector rejects tokens whose "jti" claim has already been seen
+// by detector. Tokens without a "jti" are rejected too when a detector is set.
+func WithReplayDetector(detector ReplayDetector) Option {
+	return func(v *GoogleTokenVerifier) {
+		v.replayDetector = detector
+	}
+}
+
+// WithTrialVerification makes the verifier try every key of the certs when none
+// matches the kid of the token, as long as there are no more than maxKeys of them.
+// This is a last resort for broken clients that omit or mis-set the kid.
+func WithTrialVerification(maxKeys int) Option {
+	return func(v *GoogleTokenVerifier) {
+		v.trialMaxKeys = maxKeys
+	}
+}
+
+// WithLenientBase64 accepts tokens whose segments are encoded with standard base64
+// ("+", "/" and "=" padding) instead of base64url. Such tokens are not compliant,
+// so they are rejected by default.
+func WithLenientBase64() Option {
+	return func(v *GoogleTokenVerifier) {
+		v.lenientBase64 = true
+	}
+}
+
+// WithVerifyTimeout caps how long a verification can take, including any synchronous
+// certs refresh. When exceeded, the last certs loaded are used if they are within the
+// stale grace period of the provider, see WithStaleGracePeriod, otherwise the verification
+// fails with ErrVerifyTimeout.
+func WithVerifyTimeout(d time.Duration) Option {
+	return func(v *GoogleTokenVerifier) {
+		v.verifyTimeout = d
+	}
+}
+
+// WithClaimsFactory decodes the claims of every valid token into the value returned
+// by factory, which must be a pointer suitable for json.Unmarshal. The standard claims
+// are still validated by the verifier, and the custom claims are only decoded once the
+// token has been fully verified. They are returned by TokenInfo.CustomClaims.
+func WithClaimsFactory(factory func() interface{}) Option {
+	return func(v *GoogleTokenVerifier) {
+		v.claimsFactory = factory
+	}
+}
+
+// WithIssuers accepts tokens issued by any of issuers instead of Google ones. For Firebase
+// Authentication ID tokens, use FirebaseIssuer(projectID) with the project ID as audience,
+// and certs from a provider created with NewCachedURLCertsProviderWithURL(FirebaseCertsURL).
+func WithIssuers(issuers ...string) Option {
+	return func(v *GoogleTokenVerifier) {
+		v.issuers = append([]string(nil), issuers...)
+	}
+}
+
+// WithCaseInsensitiveIssuer matches the issuer of tokens regardless of its case,
+// for buggy clients that uppercase it. Issuers are case-sensitive per spec, so this is off by default.
+func WithCaseInsensitiveIssuer() Option {
+	return func(v *GoogleTokenVerifier) {
+		v.caseInsensitiveIssuer = true
+	}
+}
+
+// WithClientPlatforms maps client IDs (audiences) to the platform of the client,
+// which is then returned by TokenInfo.Platform on verified tokens
+func WithClientPlatforms(platforms map[string]Platform) Option {
+	return func(v *GoogleTokenVerifier) {
+		v.clientPlatforms = make(map[string]Platform, len(platforms))
+		for clientID, platform := range platforms {
+			v.clientPlatforms[clientID] = platform
+		}
+	}
+}
+
+// WithObserver sets the observer notified of noteworthy events during verification
+func WithObserver(observer Observer) Option {
+	return func(v *GoogleTokenVerifier) {
+		v.observer = observer
+	}
+}
+
+// WithIatCertsSkewCheck flags as an anomaly any token whose iat is more than maxSkew away
+// from the time the certs of a CachedURLCertsProvider were fetched. maxSkew should be
+// larger than the lifetime of the certs. Anomalies are only reported to the observer
+// unless WithRejectAnomalies is used.
+func WithIatCertsSkewCheck(maxSkew time.Duration) Option {
+	return func(v *GoogleTokenVerifier) {
+		v.maxIatSkew = maxSkew
+	}
+}
+
+// WithRejectAnomalies makes anomalies fatal, tokens with an anomaly fail with ErrAnomaly
+func WithRejectAnomalies() Option {
+	return func(v *GoogleTokenVerifier) {
+		v.rejectAnomalies = true
+	}
+}
+
+// WithNumericSubject rejects tokens whose sub is not a string of digits, as Google subjects are.
+// Such tokens fail with ErrInvalidSubjectFormat.
+func WithNumericSubject() Option {
+	return func(v *GoogleTokenVerifier) {
+		v.numericSubject = true
+	}
+}
+
+// WithBlockedEmailDomains rejects tokens whose verified email belongs to one of domains,
+// compared case-insensitively. Such tokens fail with ErrEmailDomainBlocked. Emails that are
+// not verified are not checked, as nothing proves they belong to the user.
+func WithBlockedEmailDomains(domains ...string) Option {
+	return func(v *GoogleTokenVerifier) {
+		if v.blockedEmailDomains == nil {
+			v.blockedEmailDomains = make(map[string]bool, len(domains))
+		}
+		for _, domain := range domains {
+			v.blockedEmailDomains[strings.ToLower(domain)] = true
+		}
+	}
+}
+
+// WithRequireVerifiedEmail rejects tokens with an email whose email_verified is false, as
+// nothing proves the email belongs to the user. Such tokens fail with ErrEmailNotVerified.
+// Tokens without an email are accepted.
+func WithRequireVerifiedEmail() Option {
+	return func(v *GoogleTokenVerifier) {
+		v.requireVerifiedEmail = true
+	}
+}
+
+// WithMaxClaimValueBytes rejects tokens with any string claim, custom and nested ones included,
+// larger than n bytes. Such tokens fail with ErrClaimTooLarge.
+func WithMaxClaimValueBytes(n int) Option {
+	return func(v *GoogleTokenVerifier) {
+		v.maxClaimValueBytes = n
+	}
+}
+
+// WithStrictClientCheck does the client check recommended by Google on top of the audience check:
+// either the aud or the azp of the token must be one of clientIDs, and when the token has no azp,
+// its aud must be. Tokens failing it fail with ErrClientIDMismatch.
+func WithStrictClientCheck(clientIDs ...string) Option {
+	return func(v *GoogleTokenVerifier) {
+		v.strictClientIDs = append([]string{}, clientIDs...)
+	}
+}
+
+// WithAuthorizedParty rejects tokens whose azp is not azp, tokens without azp included. The aud of a
+// token is the client ID it was issued for, which is checked against the audience passed to Verify,
+// while its azp is the client ID of the party that requested it. They only differ when a client
+// requests a token for another one, such as an Android app getting a token for its backend with the
+// backend client ID as audience. Use this option to only accept the tokens requested by one client.
+// Such tokens fail with ErrAuthorizedPartyMismatch.
+func WithAuthorizedParty(azp string) Option {
+	return func(v *GoogleTokenVerifier) {
+		v.authorizedParty = azp
+	}
+}
+
+// WithStrictHeaderType rejects tokens whose header typ is not typ, such as "JWT", tokens without
+// typ included. It prevents other kinds of tokens signed by the same keys, such as "at+jwt" access
+// tokens, from being used as ID tokens. Like media types, typ values are compared case-insensitively
+// and with or without their "application/" prefix. Such tokens fail with ErrHeaderTypeMismatch.
+func WithStrictHeaderType(typ string) Option {
+	return func(v *GoogleTokenVerifier) {
+		v.headerType = typ
+	}
+}
+
+// WithLeeway sets the clock skew tolerated when checking iat and exp, 30 seconds by default.
+// A token is accepted from iat-leeway until exp+leeway.
+func WithLeeway(leeway time.Duration) Option {
+	return func(v *GoogleTokenVerifier) {
+		v.leeway = leeway
+	}
+}
+
+// WithMaxAge rejects tokens issued more than maxAge ago, give or take the leeway, even if they
+// have not expired yet. It is meant for endpoints requiring a recent sign-in. Such tokens fail
+// with ErrTokenTooOld.
+func WithMaxAge(maxAge time.Duration) Option {
+	return func(v *GoogleTokenVerifier) {
+		v.maxAge = maxAge
+	}
+}
+
+// WithClock sets the clock telling the time to check iat and exp against, the system time by default
+func WithClock(clock Clock) Option {
+	return func(v *GoogleTokenVerifier) {
+		v.clock = clock
+	}
+}
+
+// WithOutageExpiryGrace accepts tokens expired by up to grace, on top of the leeway, but only
+// while a CachedURLCertsProvider is serving stale certs because Google cannot be reached (see
+// WithStaleGracePeriod). It avoids logging out every user during an outage.
+//
+// WARNING: expired tokens are accepted while the grace applies, keep it as short as possible.
+func WithOutageExpiryGrace(grace time.Duration) Option {
+	return func(v *GoogleTokenVerifier) {
+		v.outageExpiryGrace = grace
+	}
+}
+
+// WithHostedDomain restricts sign-in to the users of the Google Workspace domain, rejecting tokens
+// whose hd claim is another one or is missing, as in consumer accounts. Such tokens fail with
+// ErrHostedDomainMismatch.
+func WithHostedDomain(domain string) Option {
+	return func(v *GoogleTokenVerifier) {
+		v.hostedDomain = domain
+	}
+}
+
+// WithTokenInfoClient sets the HTTP client of VerifyViaTokenInfoEndpoint, one with a 30 seconds
+// timeout by default
+func WithTokenInfoClient(client *http.Client) Option {
+	return func(v *GoogleTokenVerifier) {
+		v.httpClient = client
+	}
+}
+
+// WithTracer traces every verification with a span, with the kid, iss and aud of the token
+// and the result. Spans of certs fetches are started by the certs provider, see WithCertsTracer.
+func WithTracer(tracer Tracer) Option {
+	return func(v *GoogleTokenVerifier) {
+		v.tracer = tracer
+	}
+}
+
+// WithMetrics reports the result and duration of every verification to metrics. Refreshes of
+// the certs are reported by the certs provider, see WithCertsMetrics.
+func WithMetrics(metrics Metrics) Option {
+	return func(v *GoogleTokenVerifier) {
+		v.metrics = metrics
+	}
+}
+
+// WithLogger sets the logger of the tokens rejected by Verify, they are not logged by default
+func WithLogger(logger Logger) Option {
+	return func(v *GoogleTokenVerifier) {
+		if logger == nil {
+			logger = nopLogger{}
+		}
+		v.logger = logger
+	}
+}
+
+// WithSlogLogger sets the structured logger of the tokens rejected, logged with their kid, iss,
+// aud and the reason. A nil logger disables it, the default.
+func WithSlogLogger(logger *slog.Logger) Option {
+	return func(v *GoogleTokenVerifier) {
+		v.slogger = logger
+	}
+}
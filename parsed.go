@@ -0,0 +1,156 @@
+package GoogleIdTokenVerifier
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// TokenHeader is the JOSE header of a token
+type TokenHeader struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+	Typ string `json:"typ"`
+}
+
+// ParsedToken is a token decoded once, so several validation steps can be run on it
+// without splitting and decoding it again. Parse does not validate anything, the
+// signature and each claim are checked by the methods of the token.
+type ParsedToken struct {
+	header        TokenHeader
+	tokeninfo     *TokenInfo
+	payload       []byte
+	signature     []byte
+	messageToSign []byte
+}
+
+// Parse decodes authToken. The error is a *VerifyError of kind MalformedToken.
+func Parse(authToken string) (*ParsedToken, error) {
+	return parseToken(authToken, false)
+}
+
+// ParseUnverified decodes the claims of authToken without checking anything, for logging and
+// diagnostics only.
+//
+// WARNING: the claims returned cannot be trusted, anyone can forge them. Never use them to
+// authenticate a user, use a GoogleTokenVerifier instead.
+func ParseUnverified(authToken string) (*TokenInfo, error) {
+	token, err := Parse(authToken)
+	if err != nil {
+		return nil, err
+	}
+	return token.Claims(), nil
+}
+
+func parseToken(authToken string, lenient bool) (*ParsedToken, error) {
+	header, payload, signature, messageToSign, err := divideAuthToken(authToken, lenient)
+	if err != nil {
+		return nil, newVerifyError(MalformedToken, wrapSentinel(ErrMalformedToken, err))
+	}
+	tokeninfo, err := getTokenInfo(payload)
+	if err != nil {
+		return nil, newVerifyError(MalformedToken, wrapSentinel(ErrMalformedToken, err))
+	}
+	tokenHeader, err := parseHeader(header)
+	if err != nil {
+		return nil, newVerifyError(MalformedToken, wrapSentinel(ErrMalformedToken, err))
+	}
+	tokeninfo.header = tokenHeader
+	tokeninfo.payload = payload
+	return &ParsedToken{
+		header:        tokenHeader,
+		tokeninfo:     tokeninfo,
+		payload:       payload,
+		signature:     signature,
+		messageToSign: messageToSign,
+	}, nil
+}
+
+func parseHeader(bt []byte) (TokenHeader, error) {
+	var h TokenHeader
+	err := json.Unmarshal(bt, &h)
+	return h, err
+}
+
+// Header returns the header of the token
+func (p *ParsedToken) Header() TokenHeader {
+	return p.header
+}
+
+// Claims returns the claims of the token, they cannot be trusted until the signature is verified
+func (p *ParsedToken) Claims() *TokenInfo {
+	return p.tokeninfo
+}
+
+// VerifySignature verifies the token was signed with the key of certs matching its kid
+func (p *ParsedToken) VerifySignature(certs *Certs) error {
+	if certs == nil {
+		return newVerifyError(CertsUnavailable, errors.New("Token is not valid, no certs provided"))
+	}
+	return p.verifySignature(func(kid string) ([]verifyingKey, error) {
+		key, err := choiceKeyByKeyID(certs.Keys, kid)
+		if err != nil {
+			return nil, err
+		}
+		return []verifyingKey{certs.parsedKey(key)}, nil
+	})
+}
+
+func (p *ParsedToken) verifySignature(resolveKey keyResolver) error {
+	// any other algorithm is refused before looking at the signature
+	if p.header.Alg != "RS256" && p.header.Alg != "ES256" {
+		return newVerifyError(InvalidSignature, fmt.Errorf("%w: got %q", ErrUnsupportedAlgorithm, p.header.Alg))
+	}
+
+	candidates, err := resolveKey(p.header.Kid)
+	if err != nil {
+		return newVerifyError(InvalidSignature, wrapSentinel(ErrKeyIDNotFound, err))
+	}
+	for _, key := range candidates {
+		// a key only verifies the signatures of its own algorithm, whatever the token claims
+		if key.alg() != p.header.Alg {
+			err = fmt.Errorf("%w: got %q for a %s key", ErrUnsupportedAlgorithm, p.header.Alg, key.alg())
+			continue
+		}
+		err = key.verify(p.messageToSign, p.signature)
+		if err == nil {
+			p.tokeninfo.keyID = key.source().Kid
+			break
+		}
+	}
+	if errors.Is(err, ErrUnsupportedAlgorithm) {
+		return newVerifyError(InvalidSignature, err)
+	}
+	if err != nil {
+		return newVerifyError(InvalidSignature, wrapSentinel(ErrSignatureInvalid, err))
+	}
+	return nil
+}
+
+// CheckAudience checks the audience of the token is any of auds
+func (p *ParsedToken) CheckAudience(auds ...string) error {
+	if !AnyMatch.match(p.tokeninfo.Aud, auds) {
+		return newVerifyError(InvalidClaims, fmt.Errorf("%w: got %q", ErrAudienceMismatch, p.tokeninfo.Aud))
+	}
+	return nil
+}
+
+// CheckIssuer checks the token was issued by any of issuers, Google ones when none is given
+func (p *ParsedToken) CheckIssuer(issuers ...string) error {
+	if len(issuers) == 0 {
+		issuers = googleIssuers
+	}
+	if !containsString(issuers, p.tokeninfo.Iss) {
+		return newVerifyError(InvalidClaims, fmt.Errorf("%w: got %q", ErrIssuerMismatch, p.tokeninfo.Iss))
+	}
+	return nil
+}
+
+// CheckTime checks the token is valid at now, tolerating a clock skew of leeway
+func (p *ParsedToken) CheckTime(now time.Time, leeway time.Duration) error {
+	if err := checkTime(p.tokeninfo, now, leeway); err != nil {
+		return newVerifyError(InvalidClaims, err)
+	}
+	return nil
+}
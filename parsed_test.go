@@ -0,0 +1,85 @@
+package GoogleIdTokenVerifier
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParse(t *testing.T) {
+	now := time.Now()
+	token, err := Parse(signTestToken(t, testClaims(now)))
+	require.NoError(t, err)
+	assert.Equal(t, TokenHeader{Alg: "RS256", Kid: testKeyID, Typ: "JWT"}, token.Header())
+	assert.Equal(t, "110169484474386276334", token.Claims().Sub)
+	assert.Equal(t, Audience{testAud}, token.Claims().Aud)
+
+	_, err = Parse("abc")
+	assert.True(t, errors.Is(err, ErrMalformedToken), "got %v", err)
+	_, err = Parse("XXXXXXXXXXX.XXXXXXXXXXXX.XXXXXXXXXX")
+	assert.True(t, errors.Is(err, ErrMalformedToken), "got %v", err)
+}
+
+func TestParseUnverified(t *testing.T) {
+	// expired, for another audience and signed by nobody
+	claims := testClaims(time.Now().Add(-24 * time.Hour))
+	claims["aud"] = "other.apps.googleusercontent.com"
+	segments := strings.Split(signTestToken(t, claims), ".")
+	tokeninfo, err := ParseUnverified(segments[0] + "." + segments[1] + ".AAAA")
+	require.NoError(t, err)
+	assert.Equal(t, "110169484474386276334", tokeninfo.Sub)
+	assert.Equal(t, "https://accounts.google.com", tokeninfo.Iss)
+	assert.Equal(t, TokenHeader{Alg: "RS256", Kid: testKeyID, Typ: "JWT"}, tokeninfo.Header())
+
+	for _, authToken := range []string{"", "..", "abc", "a.b.c", segments[0] + ".!!!." + segments[2], segments[0] + ".bnVsbA.AAAA"} {
+		tokeninfo, err := ParseUnverified(authToken)
+		assert.Nil(t, tokeninfo)
+		assert.True(t, errors.Is(err, ErrMalformedToken), "got %v", err)
+	}
+}
+
+func TestParsedTokenVerifySignature(t *testing.T) {
+	certs := loadTestCerts(t)
+	authToken := signTestToken(t, testClaims(time.Now()))
+	token, err := Parse(authToken)
+	require.NoError(t, err)
+	assert.NoError(t, token.VerifySignature(certs))
+	assert.True(t, errors.Is(token.VerifySignature(&Certs{}), ErrKeyIDNotFound))
+	assert.True(t, errors.Is(token.VerifySignature(nil), ErrCertsUnavailable))
+
+	otherSub := testClaims(time.Now())
+	otherSub["sub"] = "208426113748299532117"
+	tampered := strings.Split(signTestToken(t, otherSub), ".")
+	token, err = Parse(tampered[0] + "." + strings.Split(authToken, ".")[1] + "." + tampered[2])
+	require.NoError(t, err)
+	assert.True(t, errors.Is(token.VerifySignature(certs), ErrSignatureInvalid))
+
+	token, err = Parse(signTestTokenWithHeader(t, map[string]interface{}{"alg": "HS256", "kid": testKeyID}, testClaims(time.Now())))
+	require.NoError(t, err)
+	assert.True(t, errors.Is(token.VerifySignature(certs), ErrUnsupportedAlgorithm))
+}
+
+func TestParsedTokenClaimChecks(t *testing.T) {
+	now := time.Now()
+	claims := testClaims(now)
+	claims["iss"] = "accounts.google.com"
+	token, err := Parse(signTestToken(t, claims))
+	require.NoError(t, err)
+
+	assert.NoError(t, token.CheckAudience(testAud))
+	assert.NoError(t, token.CheckAudience("web.apps.googleusercontent.com", testAud))
+	assert.True(t, errors.Is(token.CheckAudience("web.apps.googleusercontent.com"), ErrAudienceMismatch))
+
+	assert.NoError(t, token.CheckIssuer())
+	assert.NoError(t, token.CheckIssuer("accounts.google.com"))
+	assert.True(t, errors.Is(token.CheckIssuer("https://accounts.google.com"), ErrIssuerMismatch))
+
+	assert.NoError(t, token.CheckTime(now, 0))
+	assert.True(t, errors.Is(token.CheckTime(now.Add(2*time.Hour), 0), ErrTokenExpired))
+	assert.True(t, errors.Is(token.CheckTime(now.Add(-time.Minute), 0), ErrTokenNotYetValid))
+	assert.NoError(t, token.CheckTime(now.Add(-time.Minute), 2*time.Minute))
+}
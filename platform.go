@@ -0,0 +1,35 @@
+package GoogleIdTokenVerifier
+
+// Platform is the kind of client a token was issued to
+type Platform int
+
+const (
+	// UnknownPlatform is the platform of tokens whose audience is not mapped to any platform
+	UnknownPlatform Platform = iota
+	Web
+	Android
+	IOS
+)
+
+func (p Platform) String() string {
+	switch p {
+	case Web:
+		return "web"
+	case Android:
+		return "android"
+	case IOS:
+		return "ios"
+	default:
+		return "unknown"
+	}
+}
+
+// platformFor returns the platform of the first audience mapped in platforms
+func platformFor(aud Audience, platforms map[string]Platform) Platform {
+	for _, clientID := range aud {
+		if platform, ok := platforms[clientID]; ok {
+			return platform
+		}
+	}
+	return UnknownPlatform
+}
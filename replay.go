@@ -0,0 +1,10 @@
+package GoogleIdTokenVerifier
+
+import "time"
+
+// ReplayDetector keeps track of the "jti" claims of the tokens already verified.
+// Seen records jti until exp and tells if it had already been recorded before.
+// Implementations must be safe for concurrent use.
+type ReplayDetector interface {
+	Seen(jti string, exp time.Time) bool
+}
@@ -0,0 +1,89 @@
+package GoogleIdTokenVerifier
+
+import (
+	"crypto"
+	"crypto/rsa"
+	"crypto/subtle"
+	"fmt"
+	"math"
+	"math/big"
+)
+
+// sha256DigestInfo is the DER prefix of a SHA256 digest in a PKCS #1 v1.5 signature
+var sha256DigestInfo = []byte{0x30, 0x31, 0x30, 0x0d, 0x06, 0x09, 0x60, 0x86, 0x48, 0x01, 0x65, 0x03, 0x04, 0x02, 0x01, 0x05, 0x00, 0x04, 0x20}
+
+// rsaKey is the RSA public key of a Key, parsed once to be reused across verifications
+type rsaKey struct {
+	jwk Key
+	n   *big.Int
+	e   *big.Int
+	// pub is nil when the exponent does not fit in rsa.PublicKey.E
+	pub *rsa.PublicKey
+	// err is why the key cannot verify any signature
+	err error
+}
+
+func parseRSAKey(key Key) *rsaKey {
+	n, errN := urlsafeB64decode(key.N)
+	e, errE := urlsafeB64decode(key.E)
+	k := &rsaKey{jwk: key, n: byteToInt(n), e: byteToInt(e)}
+	if errN != nil || errE != nil {
+		k.err = fmt.Errorf("%w: RSA key is not base64url encoded", ErrSignatureInvalid)
+	} else if k.e.Cmp(big.NewInt(2)) < 0 || k.n.Sign() <= 0 {
+		k.err = fmt.Errorf("%w: invalid RSA key", ErrSignatureInvalid)
+	} else if k.e.IsInt64() && k.e.Int64() <= math.MaxInt32 {
+		k.pub = &rsa.PublicKey{N: k.n, E: int(k.e.Int64())}
+	}
+	return k
+}
+
+func (k *rsaKey) alg() string {
+	return "RS256"
+}
+
+// verify verifies the RS256 signature of a token whose SHA256 sum is hashed.
+// Keys whose exponent does not fit in rsa.PublicKey.E are verified by hand.
+func (k *rsaKey) verify(hashed []byte, signature []byte) error {
+	if k.err != nil {
+		return k.err
+	}
+	if k.pub != nil {
+		return rsa.VerifyPKCS1v15(k.pub, crypto.SHA256, hashed, signature)
+	}
+	return verifyPKCS1v15BigExponent(k.n, k.e, hashed, signature)
+}
+
+func (k *rsaKey) source() Key {
+	return k.jwk
+}
+
+// verifyPKCS1v15BigExponent is rsa.VerifyPKCS1v15 for SHA256 with an arbitrarily large exponent
+func verifyPKCS1v15BigExponent(n *big.Int, e *big.Int, hashed []byte, signature []byte) error {
+	k := (n.BitLen() + 7) / 8
+	tLen := len(sha256DigestInfo) + len(hashed)
+	if len(signature) != k || k < tLen+11 {
+		return rsa.ErrVerification
+	}
+	s := new(big.Int).SetBytes(signature)
+	if s.Cmp(n) >= 0 {
+		return rsa.ErrVerification
+	}
+
+	m := new(big.Int).Exp(s, e, n).Bytes()
+	em := make([]byte, k)
+	copy(em[k-len(m):], m)
+
+	// EM = 0x00 || 0x01 || PS || 0x00 || DigestInfo || hash
+	expected := make([]byte, k)
+	expected[1] = 1
+	for i := 2; i < k-tLen-1; i++ {
+		expected[i] = 0xff
+	}
+	copy(expected[k-tLen:], sha256DigestInfo)
+	copy(expected[k-len(hashed):], hashed)
+
+	if subtle.ConstantTimeCompare(em, expected) != 1 {
+		return rsa.ErrVerification
+	}
+	return nil
+}
@@ -0,0 +1,180 @@
+package GoogleIdTokenVerifier
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBigExponentMatchesStandardVerification(t *testing.T) {
+	authToken := signTestToken(t, testClaims(time.Now()))
+	parts := strings.Split(authToken, ".")
+	hashed := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	require.NoError(t, err)
+
+	pub := loadTestPrivateKey(t).PublicKey
+	e := big.NewInt(int64(pub.E))
+	assert.NoError(t, verifyPKCS1v15BigExponent(pub.N, e, hashed[:], signature))
+	hashed[0] ^= 0xff
+	assert.Error(t, verifyPKCS1v15BigExponent(pub.N, e, hashed[:], signature))
+}
+
+func TestVerifyWithBigExponent(t *testing.T) {
+	n, e, d := generateBigExponentKey(t)
+	require.False(t, e.IsInt64(), "the exponent must exceed the int range")
+	jwk := Key{
+		Kty: "RSA",
+		Alg: "RS256",
+		Use: "sig",
+		Kid: "big-exponent",
+		N:   base64.RawURLEncoding.EncodeToString(n.Bytes()),
+		E:   base64.RawURLEncoding.EncodeToString(e.Bytes()),
+	}
+
+	header, err := json.Marshal(map[string]string{"alg": "RS256", "kid": "big-exponent"})
+	require.NoError(t, err)
+	claims, err := json.Marshal(testClaims(time.Now()))
+	require.NoError(t, err)
+	messageToSign := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(claims)
+	hashed := sha256.Sum256([]byte(messageToSign))
+
+	// PKCS #1 v1.5 signature done by hand, crypto/rsa does not support such exponents
+	k := (n.BitLen() + 7) / 8
+	em := make([]byte, k)
+	em[1] = 1
+	tLen := len(sha256DigestInfo) + len(hashed)
+	for i := 2; i < k-tLen-1; i++ {
+		em[i] = 0xff
+	}
+	copy(em[k-tLen:], sha256DigestInfo)
+	copy(em[k-len(hashed):], hashed[:])
+	s := new(big.Int).Exp(new(big.Int).SetBytes(em), d, n).Bytes()
+	signature := make([]byte, k)
+	copy(signature[k-len(s):], s)
+	authToken := messageToSign + "." + base64.RawURLEncoding.EncodeToString(signature)
+
+	verifier := New(NewStaticCertsProvider())
+	tokeninfo, err := verifier.VerifyWithJWK(authToken, testAud, jwk)
+	require.NoError(t, err)
+	assert.NotNil(t, tokeninfo)
+
+	verifier = New(&StaticCertsProvider{certs: &Certs{Keys: []Key{jwk, loadTestCerts(t).Keys[1]}}})
+	assert.NotNil(t, verifier.Verify(authToken, testAud))
+
+	signature[k-1] ^= 0x01
+	tampered := messageToSign + "." + base64.RawURLEncoding.EncodeToString(signature)
+	_, err = verifier.VerifyWithJWK(tampered, testAud, jwk)
+	assert.Error(t, err)
+}
+
+// generateBigExponentKey returns the modulus and exponents of a RSA key whose public exponent is 96 bits long
+func generateBigExponentKey(t *testing.T) (n *big.Int, e *big.Int, d *big.Int) {
+	one := big.NewInt(1)
+	for {
+		p, err := rand.Prime(rand.Reader, 1024)
+		require.NoError(t, err)
+		q, err := rand.Prime(rand.Reader, 1024)
+		require.NoError(t, err)
+		e, err = rand.Prime(rand.Reader, 96)
+		require.NoError(t, err)
+		phi := new(big.Int).Mul(new(big.Int).Sub(p, one), new(big.Int).Sub(q, one))
+		d = new(big.Int).ModInverse(e, phi)
+		if d != nil {
+			return new(big.Int).Mul(p, q), e, d
+		}
+	}
+}
+
+func TestParsedKeysCache(t *testing.T) {
+	ts := httptest.NewServer(getTestCertsHandlerFunc(t, time.Hour*2, nil))
+	defer ts.Close()
+	certProv, err := newCachedURLCertsProvider(ts.URL)
+	require.NoError(t, err)
+
+	certs, err := certProv.GetCerts()
+	require.NoError(t, err)
+	key, err := choiceKeyByKeyID(certs.Keys, testKeyID)
+	require.NoError(t, err)
+	parsed := certs.parsedKey(key)
+	assert.Same(t, parsed, certs.parsedKey(key), "keys must be parsed once")
+	assert.NotNil(t, New(certProv).Verify(signTestToken(t, testClaims(time.Now())), testAud))
+
+	// refreshed certs come with their own parsed keys
+	certProv.expireForTest()
+	refreshed, err := certProv.GetCerts()
+	require.NoError(t, err)
+	assert.NotSame(t, parsed, refreshed.parsedKey(key))
+
+	// a key changed after parsing is parsed again
+	changed := key
+	changed.N = loadTestCerts(t).Keys[1].N
+	assert.Equal(t, changed, certs.parsedKey(changed).source())
+	assert.Error(t, certs.parsedKey(changed).verify([]byte("hashed"), []byte("signature")))
+
+	// certs that were not loaded by a provider are parsed on demand
+	assert.Equal(t, key, (&Certs{Keys: []Key{key}}).parsedKey(key).source())
+}
+
+// benchmarkRSAKey measures getting the RSA key verifying a signature, the work saved by parsing keys once
+func benchmarkRSAKey(b *testing.B, certs *Certs) {
+	key, err := choiceKeyByKeyID(certs.Keys, testKeyID)
+	require.NoError(b, err)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if k := certs.parsedKey(key).(*rsaKey); k.err != nil {
+			b.Fatal(k.err)
+		}
+	}
+}
+
+func BenchmarkRSAKeyParsedCerts(b *testing.B) {
+	benchmarkRSAKey(b, parsedCerts(loadTestCerts(b)))
+}
+
+func BenchmarkRSAKeyUnparsedCerts(b *testing.B) {
+	benchmarkRSAKey(b, loadTestCerts(b))
+}
+
+func TestKeyBase64Errors(t *testing.T) {
+	key := loadTestCerts(t).Keys[0]
+	assert.NoError(t, parseRSAKey(key).err)
+
+	// a key with a typo is not silently parsed as a truncated one
+	broken := key
+	broken.N = key.N[:20] + "*" + key.N[21:]
+	assert.True(t, errors.Is(parseRSAKey(broken).err, ErrSignatureInvalid))
+	broken = key
+	broken.E = "A$AB"
+	assert.True(t, errors.Is(parseRSAKey(broken).err, ErrSignatureInvalid))
+}
+
+func TestParseRSAKeyExponent(t *testing.T) {
+	key := loadTestCerts(t).Keys[0]
+	require.Equal(t, "AQAB", key.E)
+	parsed := parseRSAKey(key)
+	require.NoError(t, parsed.err)
+	require.NotNil(t, parsed.pub)
+	assert.Equal(t, 65537, parsed.pub.E)
+	assert.Equal(t, int64(65537), parsed.e.Int64())
+
+	// exponents that do not fit in 32 bits are not truncated, they are verified by hand
+	wide := key
+	wide.E = base64.RawURLEncoding.EncodeToString(big.NewInt(1<<32 + 65537).Bytes())
+	parsed = parseRSAKey(wide)
+	assert.NoError(t, parsed.err)
+	assert.Nil(t, parsed.pub)
+	assert.Equal(t, int64(1<<32+65537), parsed.e.Int64())
+}
@@ -2,7 +2,7 @@
 # vim: ai:ts=8:sw=8:noet
 # Runs Go unit test suite 
 
-go test -v -short -race -coverprofile=coverage.txt -covermode=atomic ./...
+go test -v -short -race -tags grpc -coverprofile=coverage.txt -covermode=atomic ./...
 
 # Display coverage
 # go tool cover -func=.test_coverage.txt | tail -n1 | awk '{print "Total test coverage: " $3}'
\ No newline at end of file
@@ -1,22 +1,113 @@
 package GoogleIdTokenVerifier
 
+import (
+	"encoding/json"
+	"time"
+)
+
 // TokenInfo is an ID token as defined in https://auth0.com/docs/tokens#id-tokens
 // Access token used in token-based authentication to gain access to resources by using them as bearer tokens.
 // Refresh token is a long-lived special kind of token used to obtain a renewed access token.
 // ID token carries identity information encoded in the token itself, which must be a JWT. It must not contain any authorization information, or any audience information — it is merely an identifier for the user.
 type TokenInfo struct {
-	Sub           string `json:"sub"`
-	Email         string `json:"email"`
-	AtHash        string `json:"at_hash"`
-	Aud           string `json:"aud"`
-	EmailVerified bool   `json:"email_verified"`
-	Name          string `json:"name"`
-	GivenName     string `json:"given_name"`
-	FamilyName    string `json:"family_name"`
-	Picture       string `json:"picture"`
-	Local         string `json:"locale"`
-	Iss           string `json:"iss"`
-	Azp           string `json:"azp"`
-	Iat           int64  `json:"iat"`
-	Exp           int64  `json:"exp"`
+	Sub           string   `json:"sub"`
+	Email         string   `json:"email"`
+	AtHash        string   `json:"at_hash"`
+	Aud           Audience `json:"aud"`
+	EmailVerified bool     `json:"email_verified"`
+	Name          string   `json:"name"`
+	GivenName     string   `json:"given_name"`
+	FamilyName    string   `json:"family_name"`
+	Picture       string   `json:"picture"`
+	Local         string   `json:"locale"` // the locale claim, see LocaleTag
+	Iss           string   `json:"iss"`
+	Azp           string   `json:"azp"`
+	Iat           int64    `json:"iat"`
+	Exp           int64    `json:"exp"`
+	Jti           string   `json:"jti"`
+	Hd            string   `json:"hd"`
+	Nonce         string   `json:"nonce"`
+
+	custom   interface{}
+	platform Platform
+	header   TokenHeader
+	payload  []byte
+	// keyID is the kid of the key that verified the signature
+	keyID string
+	// clock and leeway are the ones of the verifier, see IsExpired
+	clock  Clock
+	leeway time.Duration
+}
+
+// IssuedAt returns the iat claim
+func (t *TokenInfo) IssuedAt() time.Time {
+	return time.Unix(t.Iat, 0)
+}
+
+// ExpiresAt returns the exp claim
+func (t *TokenInfo) ExpiresAt() time.Time {
+	return time.Unix(t.Exp, 0)
+}
+
+// IsExpired tells if the token is expired now, according to the clock and leeway of the
+// verifier that verified it, or to the current time for tokens that were not verified
+func (t *TokenInfo) IsExpired() bool {
+	now := time.Now()
+	if t.clock != nil {
+		now = t.clock.Now()
+	}
+	return now.Add(-t.leeway).Unix() > t.Exp
+}
+
+// IsFirstParty tells if the token was requested by the client it was issued for, its only
+// audience, rather than by another client on its behalf, such as an Android app requesting a
+// token for its backend. Per OIDC, a token without azp was requested by its audience.
+func (t *TokenInfo) IsFirstParty() bool {
+	return len(t.Aud) == 1 && (t.Azp == "" || t.Azp == t.Aud[0])
+}
+
+// Header returns the decoded header of the token
+func (t *TokenInfo) Header() TokenHeader {
+	return t.header
+}
+
+// RawClaims returns all the claims of the token, the ones without a field in TokenInfo included.
+// Numbers are float64 as decoded by encoding/json, the map is a new one on every call.
+func (t *TokenInfo) RawClaims() map[string]interface{} {
+	claims := map[string]interface{}{}
+	_ = json.Unmarshal(t.payload, &claims)
+	return claims
+}
+
+// Platform returns the platform of the client the token was issued to, according to
+// the client platforms of the verifier
+func (t *TokenInfo) Platform() Platform {
+	return t.platform
+}
+
+// CustomClaims returns the claims of the token decoded into the type returned by the
+// claims factory of the verifier, or nil when the verifier has no claims factory
+func (t *TokenInfo) CustomClaims() interface{} {
+	return t.custom
+}
+
+// WorkspaceInfo holds the claims specific to Google Workspace accounts
+type WorkspaceInfo struct {
+	// HostedDomain is the Workspace domain of the user
+	HostedDomain  string
+	Email         string
+	EmailVerified bool
+}
+
+// Workspace returns the Workspace claims of the token. The bool tells if the token
+// belongs to a Workspace account, consumer accounts have no hosted domain.
+func (t *TokenInfo) Workspace() (WorkspaceInfo, bool) {
+	if t.Hd == "" {
+		return WorkspaceInfo{}, false
+	}
+	return WorkspaceInfo{
+		HostedDomain:  t.Hd,
+		Email:         t.Email,
+		EmailVerified: t.EmailVerified,
+	}, true
 }
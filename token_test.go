@@ -0,0 +1,161 @@
+package GoogleIdTokenVerifier
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/text/language"
+)
+
+func TestWorkspace(t *testing.T) {
+	verifier := New(&StaticCertsProvider{certs: loadTestCerts(t)})
+
+	claims := testClaims(time.Now())
+	claims["email"] = "jane@example.com"
+	claims["hd"] = "example.com"
+	tokeninfo := verifier.Verify(signTestToken(t, claims), testAud)
+	require.NotNil(t, tokeninfo)
+	workspace, ok := tokeninfo.Workspace()
+	assert.True(t, ok)
+	assert.Equal(t, WorkspaceInfo{HostedDomain: "example.com", Email: "jane@example.com", EmailVerified: true}, workspace)
+
+	// consumer accounts have no hosted domain
+	tokeninfo = verifier.Verify(signTestToken(t, testClaims(time.Now())), testAud)
+	require.NotNil(t, tokeninfo)
+	workspace, ok = tokeninfo.Workspace()
+	assert.False(t, ok)
+	assert.Empty(t, workspace)
+}
+
+func TestLocaleTag(t *testing.T) {
+	tests := []struct {
+		testName string
+		locale   string
+		expTag   language.Tag
+		expError bool
+	}{
+		{"Language", "en", language.English, false},
+		{"Language and region", "pt-BR", language.BrazilianPortuguese, false},
+		{"Empty locale", "", language.Und, false},
+		{"Malformed locale", "not a locale", language.Und, true},
+	}
+
+	for _, tc := range tests {
+		// nolint
+		t.Run(tc.testName, func(t *testing.T) {
+			tokeninfo := &TokenInfo{Local: tc.locale}
+			tag, err := tokeninfo.LocaleTag()
+			if tc.expError {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.expTag, tag)
+		})
+	}
+}
+
+func TestDisplayName(t *testing.T) {
+	tests := []struct {
+		testName   string
+		tokeninfo  TokenInfo
+		expDisplay string
+	}{
+		{"Name", TokenInfo{Name: "Test User", GivenName: "Other", FamilyName: "Name"}, "Test User"},
+		{"Given and family names", TokenInfo{GivenName: "Test", FamilyName: "User"}, "Test User"},
+		{"Given and family names in english", TokenInfo{GivenName: "Test", FamilyName: "User", Local: "en-GB"}, "Test User"},
+		{"Only given name", TokenInfo{GivenName: "Test"}, "Test"},
+		{"Only family name", TokenInfo{FamilyName: "User"}, "User"},
+		{"No names", TokenInfo{}, ""},
+		{"Japanese", TokenInfo{GivenName: "太郎", FamilyName: "山田", Local: "ja"}, "山田太郎"},
+		{"Chinese", TokenInfo{GivenName: "小明", FamilyName: "王", Local: "zh-TW"}, "王小明"},
+		{"Hungarian", TokenInfo{GivenName: "Péter", FamilyName: "Nagy", Local: "hu"}, "Nagy Péter"},
+		{"Malformed locale", TokenInfo{GivenName: "Test", FamilyName: "User", Local: "not a locale"}, "Test User"},
+	}
+
+	for _, tc := range tests {
+		// nolint
+		t.Run(tc.testName, func(t *testing.T) {
+			assert.Equal(t, tc.expDisplay, tc.tokeninfo.DisplayName())
+		})
+	}
+}
+
+func TestRawClaimsAndHeader(t *testing.T) {
+	verifier := New(&StaticCertsProvider{certs: loadTestCerts(t)})
+	claims := testClaims(time.Now())
+	claims["auth_time"] = 1600000000
+	claims["department"] = "engineering"
+	tokeninfo := verifier.Verify(signTestToken(t, claims), testAud)
+	require.NotNil(t, tokeninfo)
+
+	assert.Equal(t, TokenHeader{Alg: "RS256", Kid: testKeyID, Typ: "JWT"}, tokeninfo.Header())
+	raw := tokeninfo.RawClaims()
+	assert.Equal(t, float64(1600000000), raw["auth_time"])
+	assert.Equal(t, "engineering", raw["department"])
+	assert.Equal(t, testAud, raw["aud"])
+	assert.Equal(t, float64(claims["iat"].(int64)), raw["iat"])
+
+	// every call returns a new map
+	raw["department"] = "sales"
+	assert.Equal(t, "engineering", tokeninfo.RawClaims()["department"])
+
+	assert.Empty(t, (&TokenInfo{}).RawClaims())
+}
+
+func TestTokenTimes(t *testing.T) {
+	now := time.Now().Truncate(time.Second)
+	verifier := New(&StaticCertsProvider{certs: loadTestCerts(t)}, WithClock(fixedClock(now)), WithLeeway(time.Minute))
+	tokeninfo := verifier.Verify(signTestToken(t, testClaims(now)), testAud)
+	require.NotNil(t, tokeninfo)
+	assert.True(t, tokeninfo.IssuedAt().Equal(time.Unix(tokeninfo.Iat, 0)))
+	assert.True(t, tokeninfo.ExpiresAt().Equal(time.Unix(tokeninfo.Exp, 0)))
+	assert.False(t, tokeninfo.IsExpired())
+
+	// the clock and leeway of the verifier apply
+	tokeninfo.clock = fixedClock(tokeninfo.ExpiresAt().Add(30 * time.Second))
+	assert.False(t, tokeninfo.IsExpired())
+	tokeninfo.clock = fixedClock(tokeninfo.ExpiresAt().Add(2 * time.Minute))
+	assert.True(t, tokeninfo.IsExpired())
+
+	// tokens that were not verified use the current time
+	assert.True(t, (&TokenInfo{Exp: now.Add(-time.Second).Unix()}).IsExpired())
+	assert.False(t, (&TokenInfo{Exp: now.Add(time.Hour).Unix()}).IsExpired())
+}
+
+func TestIsFirstParty(t *testing.T) {
+	const androidClientID = "YYYYYYYYYYYYYYYYYYYYYYYYYYYYYYY.apps.googleusercontent.com"
+	tests := []struct {
+		testName      string
+		aud           Audience
+		azp           string
+		expFirstParty bool
+	}{
+		{"Same aud and azp", Audience{testAud}, testAud, true},
+		{"No azp", Audience{testAud}, "", true},
+		{"Other azp", Audience{testAud}, androidClientID, false},
+		{"Several audiences", Audience{testAud, androidClientID}, testAud, false},
+		{"No audience", Audience{}, "", false},
+	}
+
+	for _, tc := range tests {
+		// nolint
+		t.Run(tc.testName, func(t *testing.T) {
+			tokeninfo := &TokenInfo{Aud: tc.aud, Azp: tc.azp}
+			assert.Equal(t, tc.expFirstParty, tokeninfo.IsFirstParty())
+		})
+	}
+
+	// verified tokens
+	verifier := New(&StaticCertsProvider{certs: loadTestCerts(t)})
+	claims := testClaims(time.Now())
+	tokeninfo, err := verifier.VerifyE(signTestToken(t, claims), testAud)
+	require.NoError(t, err)
+	assert.True(t, tokeninfo.IsFirstParty())
+	claims["azp"] = androidClientID
+	tokeninfo, err = verifier.VerifyE(signTestToken(t, claims), testAud)
+	require.NoError(t, err)
+	assert.False(t, tokeninfo.IsFirstParty())
+}
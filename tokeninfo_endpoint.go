@@ -0,0 +1,90 @@
+package GoogleIdTokenVerifier
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+)
+
+// GoogleTokenInfoURL is the endpoint of Google verifying ID tokens remotely
+const GoogleTokenInfoURL string = "https://oauth2.googleapis.com/tokeninfo"
+
+// ErrTokenInfoRejected is a token the tokeninfo endpoint refused, see VerifyViaTokenInfoEndpoint
+var ErrTokenInfoRejected = errors.New("Token is not valid, rejected by the tokeninfo endpoint")
+
+// VerifyViaTokenInfoEndpoint verifies authToken by asking Google's tokeninfo endpoint, then
+// checks the claims returned like VerifyE does, audience, issuer and expiry included. The
+// request is bounded by ctx and sent with the client of WithTokenInfoClient.
+//
+// It is meant for debugging, or for when verifying with the certs fails. Every call adds the
+// latency of a request to Google and depends on it being reachable, use VerifyE otherwise.
+func (v *GoogleTokenVerifier) VerifyViaTokenInfoEndpoint(ctx context.Context, authToken string, aud string) (*TokenInfo, error) {
+	payload, err := v.fetchTokenInfo(ctx, authToken)
+	if err != nil {
+		return nil, err
+	}
+	tokeninfo, err := getTokenInfo(payload)
+	if err != nil {
+		return nil, newVerifyError(MalformedToken, wrapSentinel(ErrMalformedToken, err))
+	}
+	tokeninfo.payload = payload
+	if errs := v.checkClaims(tokeninfo, v.audienceMatchMode.expecting(aud), v.clock.Now()); len(errs) > 0 {
+		return nil, errs[0]
+	}
+	return v.acceptReplay(v.acceptToken(tokeninfo, payload))
+}
+
+// fetchTokenInfo returns the claims of authToken according to the tokeninfo endpoint, as JSON
+// with the types of an ID token payload
+func (v *GoogleTokenVerifier) fetchTokenInfo(ctx context.Context, authToken string) ([]byte, error) {
+	endpoint := v.tokenInfoURL
+	if endpoint == "" {
+		endpoint = GoogleTokenInfoURL
+	}
+	client := v.httpClient
+	if client == nil {
+		client = defaultHTTPClient
+	}
+	req, err := http.NewRequestWithContext(ctx, "GET", endpoint+"?"+url.Values{"id_token": {authToken}}.Encode(), nil)
+	if err != nil {
+		return nil, newVerifyError(CertsUnavailable, err)
+	}
+	res, err := client.Do(req)
+	if err != nil {
+		return nil, newVerifyError(CertsUnavailable, err)
+	}
+	defer res.Body.Close()
+
+	// tokens that are not valid get a 400 Bad Request
+	if res.StatusCode == http.StatusBadRequest {
+		body, _ := ioutil.ReadAll(io.LimitReader(res.Body, maxCertsErrorBodyBytes))
+		return nil, newVerifyError(InvalidSignature, fmt.Errorf("%w: %s", ErrTokenInfoRejected, body))
+	}
+	if res.StatusCode != http.StatusOK {
+		return nil, newVerifyError(CertsUnavailable, fmt.Errorf("Unsuccessful status code from the tokeninfo endpoint: %v", res.StatusCode))
+	}
+
+	// the response is as small as the certs are, it is capped the same way
+	var claims map[string]interface{}
+	if err := json.NewDecoder(io.LimitReader(res.Body, maxCertsBodyBytes)).Decode(&claims); err != nil {
+		return nil, newVerifyError(MalformedToken, fmt.Errorf("%w: tokeninfo response is not a JSON object: %v", ErrMalformedToken, err))
+	}
+	// the endpoint returns every claim as a string
+	for _, name := range []string{"iat", "exp", "nbf", "auth_time"} {
+		if str, ok := claims[name].(string); ok {
+			if num, err := strconv.ParseInt(str, 10, 64); err == nil {
+				claims[name] = num
+			}
+		}
+	}
+	if str, ok := claims["email_verified"].(string); ok {
+		claims["email_verified"] = str == "true"
+	}
+	return json.Marshal(claims)
+}
@@ -0,0 +1,111 @@
+package GoogleIdTokenVerifier
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// tokenInfoHandler answers like the tokeninfo endpoint, with the claims of the tokens in claims
+// as strings, and 400 Bad Request for other tokens
+func tokenInfoHandler(claims map[string]map[string]interface{}) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		tokenClaims, ok := claims[r.URL.Query().Get("id_token")]
+		if !ok {
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = w.Write([]byte(`{"error": "invalid_token", "error_description": "Invalid Value"}`))
+			return
+		}
+		response := map[string]string{}
+		for name, value := range tokenClaims {
+			switch value := value.(type) {
+			case int64:
+				response[name] = strconv.FormatInt(value, 10)
+			case bool:
+				response[name] = strconv.FormatBool(value)
+			default:
+				response[name] = value.(string)
+			}
+		}
+		_ = json.NewEncoder(w).Encode(response)
+	}
+}
+
+func TestVerifyViaTokenInfoEndpoint(t *testing.T) {
+	valid := testClaims(time.Now())
+	expired := testClaims(time.Now().Add(-2 * time.Hour))
+	otherIss := testClaims(time.Now())
+	otherIss["iss"] = "https://evil.example.com"
+	huge := testClaims(time.Now())
+	huge["name"] = strings.Repeat("a", maxCertsBodyBytes)
+	ts := httptest.NewServer(tokenInfoHandler(map[string]map[string]interface{}{
+		"valid":     valid,
+		"expired":   expired,
+		"other-iss": otherIss,
+		"huge":      huge,
+	}))
+	defer ts.Close()
+
+	client := &countingTransport{}
+	verifier := New(failingCertsProvider{}, WithTokenInfoClient(&http.Client{Transport: client}))
+	verifier.tokenInfoURL = ts.URL
+
+	tests := []struct {
+		testName  string
+		authToken string
+		aud       string
+		expErr    error
+	}{
+		{"Valid token", "valid", testAud, nil},
+		{"Other audience", "valid", "other.apps.googleusercontent.com", ErrAudienceMismatch},
+		{"Expired token", "expired", testAud, ErrTokenExpired},
+		{"Other issuer", "other-iss", testAud, ErrIssuerMismatch},
+		{"Rejected token", "forged", testAud, ErrTokenInfoRejected},
+		{"Response too large", "huge", testAud, ErrMalformedToken},
+	}
+
+	for _, tc := range tests {
+		// nolint
+		t.Run(tc.testName, func(t *testing.T) {
+			tokeninfo, err := verifier.VerifyViaTokenInfoEndpoint(context.Background(), tc.authToken, tc.aud)
+			if tc.expErr != nil {
+				assert.Nil(t, tokeninfo)
+				assert.True(t, errors.Is(err, tc.expErr), "got %v", err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, "110169484474386276334", tokeninfo.Sub)
+			assert.Equal(t, valid["exp"], tokeninfo.Exp)
+			assert.True(t, tokeninfo.EmailVerified)
+		})
+	}
+	assert.Equal(t, int32(len(tests)), client.count, "the client given is used")
+}
+
+func TestVerifyViaTokenInfoEndpointUnavailable(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer ts.Close()
+	verifier := New(failingCertsProvider{})
+	verifier.tokenInfoURL = ts.URL
+
+	_, err := verifier.VerifyViaTokenInfoEndpoint(context.Background(), "token", testAud)
+	var verr *VerifyError
+	require.True(t, errors.As(err, &verr))
+	assert.Equal(t, CertsUnavailable, verr.Kind)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = verifier.VerifyViaTokenInfoEndpoint(ctx, "token", testAud)
+	assert.True(t, errors.Is(err, context.Canceled), "got %v", err)
+}
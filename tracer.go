@@ -0,0 +1,52 @@
+package GoogleIdTokenVerifier
+
+import (
+	"context"
+	"log/slog"
+)
+
+// Tracer starts the spans of verifications and certs fetches, see WithTracer and WithCertsTracer.
+// Nothing is traced by default. It is a subset of an OpenTelemetry trace.Tracer, which can be
+// adapted in a few lines without this package depending on OpenTelemetry:
+//
+//	type otelTracer struct{ trace.Tracer }
+//
+//	func (t otelTracer) Start(ctx context.Context, name string) (context.Context, verifier.Span) {
+//		ctx, span := t.Tracer.Start(ctx, name)
+//		return ctx, otelSpan{span}
+//	}
+//
+// where otelSpan converts the slog attributes to attribute.KeyValue.
+type Tracer interface {
+	Start(ctx context.Context, spanName string) (context.Context, Span)
+}
+
+// Span is a span started by a Tracer. Attributes never include the token itself.
+type Span interface {
+	SetAttributes(attrs ...slog.Attr)
+	RecordError(err error)
+	End()
+}
+
+// nopSpan is the span of operations that are not traced
+type nopSpan struct{}
+
+func (nopSpan) SetAttributes(attrs ...slog.Attr) {}
+func (nopSpan) RecordError(err error)            {}
+func (nopSpan) End()                             {}
+
+// startSpan starts a span with tracer, a span doing nothing if there is no tracer
+func startSpan(tracer Tracer, ctx context.Context, spanName string) (context.Context, Span) {
+	if tracer == nil {
+		return ctx, nopSpan{}
+	}
+	return tracer.Start(ctx, spanName)
+}
+
+// endSpan records err, if any, and ends span
+func endSpan(span Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+	}
+	span.End()
+}
@@ -0,0 +1,112 @@
+package GoogleIdTokenVerifier
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// recordedSpan is a span of a recordingTracer
+type recordedSpan struct {
+	tracer *recordingTracer
+	name   string
+	parent string
+	attrs  map[string]slog.Value
+	err    error
+}
+
+func (s *recordedSpan) SetAttributes(attrs ...slog.Attr) {
+	s.tracer.mutex.Lock()
+	defer s.tracer.mutex.Unlock()
+	for _, attr := range attrs {
+		s.attrs[attr.Key] = attr.Value
+	}
+}
+
+func (s *recordedSpan) RecordError(err error) {
+	s.tracer.mutex.Lock()
+	defer s.tracer.mutex.Unlock()
+	s.err = err
+}
+
+func (s *recordedSpan) End() {
+	s.tracer.mutex.Lock()
+	defer s.tracer.mutex.Unlock()
+	s.tracer.ended = append(s.tracer.ended, s)
+}
+
+type spanContextKey struct{}
+
+// recordingTracer keeps the spans ended, with the name of their parent span
+type recordingTracer struct {
+	mutex sync.Mutex
+	ended []*recordedSpan
+}
+
+func (t *recordingTracer) Start(ctx context.Context, spanName string) (context.Context, Span) {
+	span := &recordedSpan{tracer: t, name: spanName, attrs: map[string]slog.Value{}}
+	if parent, ok := ctx.Value(spanContextKey{}).(*recordedSpan); ok {
+		span.parent = parent.name
+	}
+	return context.WithValue(ctx, spanContextKey{}, span), span
+}
+
+func (t *recordingTracer) spans() []*recordedSpan {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	return append([]*recordedSpan(nil), t.ended...)
+}
+
+func TestTracer(t *testing.T) {
+	ts := httptest.NewServer(getTestCertsHandlerFunc(t, time.Hour*2, nil))
+	defer ts.Close()
+	tracer := &recordingTracer{}
+	certProv := newUnloadedCertsProvider(ts.URL, defaultRefreshBefore, WithCertsTracer(tracer), WithSynchronousRefresh())
+	verifier := New(certProv, WithTracer(tracer))
+
+	// the first verification fetches the certs
+	authToken := signTestToken(t, testClaims(time.Now()))
+	_, err := verifier.VerifyContext(context.Background(), authToken, testAud)
+	require.NoError(t, err)
+	spans := tracer.spans()
+	require.Len(t, spans, 3)
+	fetch, getCerts, verify := spans[0], spans[1], spans[2]
+
+	assert.Equal(t, "GoogleIdTokenVerifier.FetchCerts", fetch.name)
+	assert.Equal(t, "GoogleIdTokenVerifier.GetCerts", fetch.parent)
+	assert.Equal(t, ts.URL, fetch.attrs["http.url"].String())
+	assert.Equal(t, int64(http.StatusOK), fetch.attrs["http.status_code"].Int64())
+	assert.NoError(t, fetch.err)
+
+	assert.Equal(t, "GoogleIdTokenVerifier.GetCerts", getCerts.name)
+	assert.Equal(t, "GoogleIdTokenVerifier.Verify", getCerts.parent)
+	assert.False(t, getCerts.attrs["certs.cached"].Bool())
+
+	assert.Equal(t, "GoogleIdTokenVerifier.Verify", verify.name)
+	assert.Empty(t, verify.parent)
+	assert.Equal(t, "https://accounts.google.com", verify.attrs["iss"].String())
+	assert.Equal(t, testAud, verify.attrs["aud"].String())
+	assert.Equal(t, testKeyID, verify.attrs["kid"].String())
+	assert.Equal(t, "valid", verify.attrs["result"].String())
+	for _, span := range spans {
+		for _, value := range span.attrs {
+			assert.NotContains(t, value.String(), authToken)
+		}
+	}
+
+	// then the certs are cached, and failures are recorded
+	_, err = verifier.VerifyContext(context.Background(), authToken, "other.apps.googleusercontent.com")
+	require.Error(t, err)
+	spans = tracer.spans()[3:]
+	require.Len(t, spans, 2)
+	assert.True(t, spans[0].attrs["certs.cached"].Bool())
+	assert.Equal(t, InvalidClaims.String(), spans[1].attrs["result"].String())
+	assert.Equal(t, err, spans[1].err)
+}
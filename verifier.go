@@ -1,164 +1,860 @@
 package GoogleIdTokenVerifier
 
 import (
-	"bytes"
-	"crypto"
-	"crypto/rsa"
+	"context"
 	"crypto/sha256"
+	"crypto/subtle"
 	"encoding/base64"
-	"encoding/binary"
 	"encoding/json"
 	"errors"
 	"fmt"
-	"io"
-	"io/ioutil"
+	"log/slog"
+	"math"
 	"math/big"
 	"net/http"
+	"runtime"
 	"strings"
+	"sync"
 	"time"
 )
 
 // https://developers.google.com/identity/sign-in/web/backend-auth
 // https://github.com/google/oauth2client/blob/master/oauth2client/crypt.py
 
+// googleIssuers are the issuers of Google ID tokens
+var googleIssuers = []string{"accounts.google.com", "https://accounts.google.com"}
+
+// FirebaseIssuer is the issuer of the Firebase Authentication ID tokens of projectID
+func FirebaseIssuer(projectID string) string {
+	return "https://securetoken.google.com/" + projectID
+}
+
 type GoogleTokenVerifier struct {
-	certProvider CertsProvider
+	certProvider      CertsProvider
+	issuers           []string
+	audienceMatchMode AudienceMatchMode
+	replayDetector    ReplayDetector
+	trialMaxKeys      int
+	lenientBase64     bool
+	verifyTimeout     time.Duration
+	claimsFactory     func() interface{}
+	// caseInsensitiveIssuer is lenient with buggy clients, the spec says issuers are case-sensitive
+	caseInsensitiveIssuer bool
+	clientPlatforms       map[string]Platform
+	observer              Observer
+	maxIatSkew            time.Duration
+	rejectAnomalies       bool
+	numericSubject        bool
+	blockedEmailDomains   map[string]bool
+	requireVerifiedEmail  bool
+	maxClaimValueBytes    int
+	strictClientIDs       []string
+	authorizedParty       string
+	headerType            string
+	hostedDomain          string
+	// expectedNonce is only checked when requireNonce, see VerifyWithNonce
+	expectedNonce string
+	requireNonce  bool
+	logger        Logger
+	slogger       *slog.Logger
+	tracer        Tracer
+	metrics       Metrics
+	// leeway tolerates clocks skewed from Google's when checking iat and exp
+	leeway time.Duration
+	clock  Clock
+	// maxAge is how long after iat tokens are accepted, regardless of exp, see WithMaxAge
+	maxAge time.Duration
+	// outageExpiryGrace extends exp while the certs provider is degraded, see WithOutageExpiryGrace
+	outageExpiryGrace time.Duration
+	// httpClient and tokenInfoURL are used by VerifyViaTokenInfoEndpoint
+	httpClient   *http.Client
+	tokenInfoURL string
 }
 
+// defaultLeeway is the clock skew tolerated unless WithLeeway is used
+const defaultLeeway = 30 * time.Second
+
 // Default is the way to go to verify Google tokens ;-)
 var Default *GoogleTokenVerifier = New(NewCachedURLCertsProvider())
 
-func New(prv CertsProvider) *GoogleTokenVerifier {
-	return &GoogleTokenVerifier{prv}
+// Verify verifies a Google ID token with the Default verifier
+func Verify(authToken string, aud string) *TokenInfo {
+	return Default.Verify(authToken, aud)
+}
+
+// VerifyE verifies a Google ID token with the Default verifier, returning why it was rejected
+func VerifyE(authToken string, aud string) (*TokenInfo, error) {
+	return Default.VerifyE(authToken, aud)
+}
+
+func New(prv CertsProvider, opts ...Option) *GoogleTokenVerifier {
+	v := &GoogleTokenVerifier{certProvider: prv, issuers: googleIssuers, leeway: defaultLeeway, clock: realClock{}, logger: nopLogger{}}
+	for _, opt := range opts {
+		opt(v)
+	}
+	return v
+}
+
+// NewFaaS returns a verifier for FaaS environments: certs are loaded from Google
+// and refreshed synchronously, without ever launching background goroutines
+func NewFaaS(opts ...Option) *GoogleTokenVerifier {
+	return New(createDynamicCertProvider(GoogleCertsURL, defaultRefreshBefore, WithSynchronousRefresh()), opts...)
 }
 
 func (v *GoogleTokenVerifier) Verify(authToken string, aud string) *TokenInfo {
-	var niltokeninfo *TokenInfo
-	header, payload, signature, messageToSign, err := divideAuthToken(authToken)
+	tokeninfo, err := v.VerifyE(authToken, aud)
 	if err != nil {
-		return niltokeninfo
+		v.logger.Errorf("Error verifying key %s", err.Error())
+		return nil
 	}
+	return tokeninfo
+}
 
-	certs, err := v.certProvider.GetCerts()
+// VerifyE verifies authToken like Verify does, but returns the reason why the token was rejected.
+// The error is a *VerifyError telling apart malformed tokens, invalid signatures,
+// invalid claims and certs that could not be retrieved.
+func (v *GoogleTokenVerifier) VerifyE(authToken string, aud string) (*TokenInfo, error) {
+	return v.VerifyMulti(authToken, []string{aud})
+}
+
+// VerifyWithNonce verifies authToken like VerifyE does, also requiring its nonce claim to be
+// expectedNonce, the one sent by the client in the implicit or hybrid flows. Tokens without
+// nonce or with another one fail with ErrNonceMismatch.
+func (v *GoogleTokenVerifier) VerifyWithNonce(authToken string, aud string, expectedNonce string) (*TokenInfo, error) {
+	withNonce := *v
+	withNonce.expectedNonce = expectedNonce
+	withNonce.requireNonce = true
+	return withNonce.VerifyE(authToken, aud)
+}
+
+// VerifyInto verifies authToken like VerifyE does, and only once it is valid decodes its
+// claims into dest with json.Unmarshal, so dest can be any struct modeling the claims
+func (v *GoogleTokenVerifier) VerifyInto(authToken string, aud string, dest interface{}) error {
+	tokeninfo, err := v.VerifyE(authToken, aud)
 	if err != nil {
-		return niltokeninfo
+		return err
+	}
+	if err := json.Unmarshal(tokeninfo.payload, dest); err != nil {
+		return newVerifyError(MalformedToken, fmt.Errorf("Token claims could not be decoded into %T: %v", dest, err))
+	}
+	return nil
+}
+
+// VerifyMulti verifies authToken like VerifyE does, for backends accepting tokens of several
+// client IDs. With the default AnyMatch mode the token is valid if its audience is any of auds.
+func (v *GoogleTokenVerifier) VerifyMulti(authToken string, auds []string) (*TokenInfo, error) {
+	return v.verify(context.Background(), authToken, v.audienceMatchMode.expecting(auds...))
+}
+
+// VerifyFunc verifies authToken like VerifyE does, but leaves the audience check to audOK, for
+// backends accepting audiences that are not known upfront, such as any client ID of theirs or
+// ones looked up per request. audOK is called with each audience of the token, and only once its
+// signature and all its other claims have been verified. With the default AnyMatch mode the token is valid if audOK accepts
+// any of its audiences, with ExactSetMatch all of them.
+func (v *GoogleTokenVerifier) VerifyFunc(authToken string, audOK func(aud string) bool) (*TokenInfo, error) {
+	return v.verify(context.Background(), authToken, v.audienceMatchMode.accepting(audOK))
+}
+
+// VerifyContext verifies authToken like VerifyE does, bounding by ctx the time spent waiting
+// for the certs, such as a synchronous refresh. On cancellation the error wraps ctx.Err().
+func (v *GoogleTokenVerifier) VerifyContext(ctx context.Context, authToken string, aud string) (*TokenInfo, error) {
+	return v.verify(ctx, authToken, v.audienceMatchMode.expecting(aud))
+}
+
+func (v *GoogleTokenVerifier) verify(ctx context.Context, authToken string, audOK audienceMatcher) (*TokenInfo, error) {
+	start := time.Now()
+	ctx, span := startSpan(v.tracer, ctx, "GoogleIdTokenVerifier.Verify")
+	tokeninfo, err := v.verifyWithCerts(ctx, authToken, audOK)
+	if v.metrics != nil {
+		v.metrics.OnVerify(verifyResult(err), time.Since(start))
 	}
+	if v.tracer != nil {
+		result := "valid"
+		var verr *VerifyError
+		if errors.As(err, &verr) {
+			result = verr.Kind.String()
+		}
+		span.SetAttributes(append(tokenAttrs(authToken, v.lenientBase64), slog.String("result", result))...)
+	}
+	endSpan(span, err)
+	return tokeninfo, err
+}
 
-	tokeninfo, err := getTokenInfo(payload)
+func (v *GoogleTokenVerifier) verifyWithCerts(ctx context.Context, authToken string, audOK audienceMatcher) (*TokenInfo, error) {
+	ctx, cancel := v.withVerifyTimeout(ctx)
+	defer cancel()
+	certs, err := v.getCerts(ctx)
 	if err != nil {
-		return niltokeninfo
+		return nil, err
+	}
+	return v.verifyLoaded(ctx, authToken, audOK, certs)
+}
+
+// verifyLoaded verifies authToken against certs already retrieved from the provider. When they do
+// not have the key of the token, a CachedURLCertsProvider is given a chance to refresh them within
+// ctx, which is bounded by the verify timeout, see withVerifyTimeout.
+func (v *GoogleTokenVerifier) verifyLoaded(ctx context.Context, authToken string, audOK audienceMatcher, certs *Certs) (*TokenInfo, error) {
+	tokeninfo, errs := v.verifyLoadedWith(ctx, authToken, certs, func(certs *Certs) (*TokenInfo, []error) {
+		tokeninfo, err := v.verifyToken(authToken, audOK, v.clock.Now(), certs)
+		if err != nil {
+			return nil, []error{err}
+		}
+		return tokeninfo, nil
+	})
+	if len(errs) > 0 {
+		return nil, errs[0]
 	}
+	return tokeninfo, nil
+}
 
-	if aud != tokeninfo.Aud {
-		err := errors.New("Token is not valid, Audience from token and certificate don't match")
-		fmt.Printf("Error verifying key %s\n", err.Error())
-		return niltokeninfo
+// verifyLoadedWith is verifyLoaded running the checks of verify, which returns their failures
+func (v *GoogleTokenVerifier) verifyLoadedWith(ctx context.Context, authToken string, certs *Certs, verify func(certs *Certs) (*TokenInfo, []error)) (*TokenInfo, []error) {
+	tokeninfo, errs := verify(certs)
+	if len(errs) > 0 && errors.Is(errs[0], ErrKeyIDNotFound) && ctx.Err() == nil {
+		if prv, ok := v.certProvider.(*CachedURLCertsProvider); ok {
+			if refreshed := prv.refreshForUnknownKey(ctx, certs); refreshed != nil {
+				tokeninfo, errs = verify(refreshed)
+			}
+		}
 	}
-	if (tokeninfo.Iss != "accounts.google.com") && (tokeninfo.Iss != "https://accounts.google.com") {
-		err := errors.New("Token is not valid, ISS from token and certificate don't match")
-		fmt.Printf("Error verifying key %s\n", err.Error())
-		return niltokeninfo
+	if len(errs) == 0 {
+		if err := v.checkIatSkew(tokeninfo); err != nil {
+			errs = []error{err}
+		} else if err := v.checkReplay(tokeninfo); err != nil {
+			errs = []error{err}
+		}
 	}
-	if !checkTime(tokeninfo) {
-		err := errors.New("Token is not valid, Token is expired.")
-		fmt.Printf("Error verifying key %s\n", err.Error())
-		return niltokeninfo
+	if len(errs) > 0 {
+		for _, err := range errs {
+			v.logFailure(authToken, err)
+		}
+		return nil, errs
 	}
+	return tokeninfo, nil
+}
 
-	authTokenKeyID, err := getAuthTokenKeyID(header)
+// logFailure logs to the slog logger why authToken was rejected, along with what can be decoded of it
+func (v *GoogleTokenVerifier) logFailure(authToken string, err error) {
+	if v.slogger == nil {
+		return
+	}
+	attrs := []any{"reason", err.Error()}
+	var verr *VerifyError
+	if errors.As(err, &verr) {
+		attrs = append(attrs, "kind", verr.Kind.String())
+	}
+	for _, attr := range tokenAttrs(authToken, v.lenientBase64) {
+		attrs = append(attrs, attr)
+	}
+	v.slogger.Error("token verification failed", attrs...)
+}
+
+// tokenAttrs describes authToken for logs and traces without disclosing it, nothing if it cannot be decoded
+func tokenAttrs(authToken string, lenient bool) []slog.Attr {
+	token, err := parseToken(authToken, lenient)
 	if err != nil {
-		return niltokeninfo
+		return nil
+	}
+	return []slog.Attr{
+		slog.String("kid", token.header.Kid),
+		slog.String("iss", token.tokeninfo.Iss),
+		slog.String("aud", token.tokeninfo.Aud.String()),
 	}
+}
 
-	key, err := choiceKeyByKeyID(certs.Keys, authTokenKeyID)
+// withVerifyTimeout bounds ctx by the verify timeout, if any. The certs retrieved with the
+// returned context fall back to the last ones loaded when the timeout is exceeded, see getCerts.
+func (v *GoogleTokenVerifier) withVerifyTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if v.verifyTimeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeoutCause(ctx, v.verifyTimeout, ErrVerifyTimeout)
+}
+
+// getCerts returns the certs of the provider within ctx, see withVerifyTimeout. When the verify
+// timeout is exceeded, the last certs loaded by the provider are used if they are still within
+// its stale grace period.
+func (v *GoogleTokenVerifier) getCerts(ctx context.Context) (*Certs, error) {
+	certs, err := v.certProvider.GetCertsContext(ctx)
+	if err == nil {
+		// providers such as StaticCertsProvider have no certs before any is loaded
+		if certs == nil {
+			return nil, newVerifyError(CertsUnavailable, errors.New("Token is not valid, no certs loaded"))
+		}
+		return certs, nil
+	}
+	if errors.Is(context.Cause(ctx), ErrVerifyTimeout) {
+		if prv, ok := v.certProvider.(*CachedURLCertsProvider); ok {
+			if certs := prv.lastLoadedCerts(); certs != nil {
+				return certs, nil
+			}
+		}
+		return nil, newVerifyError(CertsUnavailable, ErrVerifyTimeout)
+	}
+	return nil, newVerifyError(CertsUnavailable, err)
+}
+
+// VerifyAsOf verifies authToken against the given certs as if the current time were asOf.
+// It is meant for replaying or auditing historical tokens with the certs that were valid back then.
+func (v *GoogleTokenVerifier) VerifyAsOf(authToken string, aud string, asOf time.Time, certs *Certs) (*TokenInfo, error) {
+	if certs == nil {
+		return nil, newVerifyError(CertsUnavailable, errors.New("Token is not valid, no certs provided"))
+	}
+	return v.acceptReplay(v.verifyToken(authToken, v.audienceMatchMode.expecting(aud), asOf, certs))
+}
+
+// VerifyToMap verifies all tokens against aud and returns the valid ones keyed by subject.
+// When several tokens share a subject, the last one wins.
+// There is an error for each token that could not be verified.
+func (v *GoogleTokenVerifier) VerifyToMap(tokens []string, aud string) (map[string]*TokenInfo, []error) {
+	verified := make(map[string]*TokenInfo, len(tokens))
+	var errs []error
+
+	ctx, cancel := v.withVerifyTimeout(context.Background())
+	defer cancel()
+	certs, certsErr := v.getCerts(ctx)
+	for i, authToken := range tokens {
+		start := time.Now()
+		var tokeninfo *TokenInfo
+		err := certsErr
+		if err == nil {
+			tokeninfo, err = v.verifyLoaded(ctx, authToken, v.audienceMatchMode.expecting(aud), certs)
+		}
+		if v.metrics != nil {
+			v.metrics.OnVerify(verifyResult(err), time.Since(start))
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("token %d: %w", i, err))
+			continue
+		}
+		verified[tokeninfo.Sub] = tokeninfo
+	}
+	return verified, errs
+}
+
+// VerifyResult is the outcome of the verification of one of the tokens of VerifyBatch
+type VerifyResult struct {
+	TokenInfo *TokenInfo
+	Err       error
+}
+
+// VerifyBatch verifies all tokens against aud like VerifyContext does, returning a result for
+// each of them in the same order. The certs are retrieved once and their parsed keys shared by
+// all the tokens, which are verified in parallel by up to GOMAXPROCS workers. When the certs
+// cannot be retrieved, every result fails with that error. Once ctx is done no more tokens
+// are verified, and the ones left fail with ctx.Err().
+func (v *GoogleTokenVerifier) VerifyBatch(ctx context.Context, tokens []string, aud string) []VerifyResult {
+	results := make([]VerifyResult, len(tokens))
+	if len(tokens) == 0 {
+		return results
+	}
+	audOK := v.audienceMatchMode.expecting(aud)
+
+	certsCtx, cancel := v.withVerifyTimeout(ctx)
+	defer cancel()
+	certs, err := v.getCerts(certsCtx)
 	if err != nil {
-		fmt.Printf("Error verifying key %s\n", err.Error())
-		return niltokeninfo
+		for i := range results {
+			results[i].Err = err
+		}
+		return results
+	}
+
+	workers := runtime.GOMAXPROCS(0)
+	if workers > len(tokens) {
+		workers = len(tokens)
+	}
+	indexes := make(chan int)
+	var wg sync.WaitGroup
+	wg.Add(workers)
+	for w := 0; w < workers; w++ {
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				start := time.Now()
+				tokeninfo, err := v.verifyLoaded(certsCtx, tokens[i], audOK, certs)
+				if v.metrics != nil {
+					v.metrics.OnVerify(verifyResult(err), time.Since(start))
+				}
+				results[i] = VerifyResult{TokenInfo: tokeninfo, Err: err}
+			}
+		}()
+	}
+	// stop dispatching once ctx is done, the tokens left fail with its error
+	dispatched := 0
+dispatch:
+	for ; dispatched < len(tokens) && ctx.Err() == nil; dispatched++ {
+		select {
+		case indexes <- dispatched:
+		case <-ctx.Done():
+			break dispatch
+		}
 	}
-	pKey := rsa.PublicKey{N: byteToInt(urlsafeB64decode(key.N)), E: btrToInt(byteToBtr(urlsafeB64decode(key.E)))}
-	err = rsa.VerifyPKCS1v15(&pKey, crypto.SHA256, messageToSign, signature)
+	close(indexes)
+	wg.Wait()
+	for i := dispatched; i < len(tokens); i++ {
+		results[i].Err = ctx.Err()
+	}
+	return results
+}
+
+// VerifyWithJWK verifies authToken with the given key, whatever the kid of the token is.
+// It is meant for providers that hand out a single key, and for testing.
+func (v *GoogleTokenVerifier) VerifyWithJWK(authToken string, aud string, jwk Key) (*TokenInfo, error) {
+	key := parseKey(jwk)
+	return v.acceptReplay(v.verifyTokenWithKey(authToken, v.audienceMatchMode.expecting(aud), v.clock.Now(), func(string) ([]verifyingKey, error) {
+		return []verifyingKey{key}, nil
+	}))
+}
+
+// VerifyWithJWKS verifies authToken with the certs of jwksJSON, see DecodeCerts, instead of the
+// ones of the provider. It is meant for applications that keep the certs by themselves, and for
+// testing. It fails with a CertsUnavailable error if jwksJSON is not valid.
+func (v *GoogleTokenVerifier) VerifyWithJWKS(authToken string, aud string, jwksJSON []byte) (*TokenInfo, error) {
+	certs, err := DecodeCerts(jwksJSON)
 	if err != nil {
-		fmt.Printf("Error verifying key %s\n", err.Error())
-		return niltokeninfo
+		return nil, newVerifyError(CertsUnavailable, err)
 	}
-	return tokeninfo
+	return v.acceptReplay(v.verifyToken(authToken, v.audienceMatchMode.expecting(aud), v.clock.Now(), parsedCerts(certs)))
+}
+
+func (v *GoogleTokenVerifier) verifyToken(authToken string, audOK audienceMatcher, now time.Time, certs *Certs) (*TokenInfo, error) {
+	return v.verifyTokenWithKey(authToken, audOK, now, v.certsKeyResolver(certs))
+}
+
+// certsKeyResolver picks the key of certs matching the kid of the token
+func (v *GoogleTokenVerifier) certsKeyResolver(certs *Certs) keyResolver {
+	return func(kid string) ([]verifyingKey, error) {
+		key, err := choiceKeyByKeyID(certs.Keys, kid)
+		if err == nil {
+			return []verifyingKey{certs.parsedKey(key)}, nil
+		}
+		if v.trialMaxKeys > 0 && len(certs.Keys) <= v.trialMaxKeys {
+			// last resort, any of the keys may have signed the token
+			candidates := make([]verifyingKey, len(certs.Keys))
+			for i, key := range certs.Keys {
+				candidates[i] = certs.parsedKey(key)
+			}
+			return candidates, nil
+		}
+		return nil, err
+	}
+}
+
+// keyResolver returns the candidate keys to verify a token signed with kid
+type keyResolver func(kid string) ([]verifyingKey, error)
+
+func (v *GoogleTokenVerifier) verifyTokenWithKey(authToken string, audOK audienceMatcher, now time.Time, resolveKey keyResolver) (*TokenInfo, error) {
+	tokeninfo, payload, err := v.verifySignature(authToken, resolveKey)
+	if err != nil {
+		return nil, err
+	}
+	if errs := v.checkClaims(tokeninfo, audOK, now); len(errs) > 0 {
+		return nil, errs[0]
+	}
+	return v.acceptToken(tokeninfo, payload)
+}
+
+// VerifyAll verifies authToken like Verify does, but instead of stopping at the first
+// failing check it runs all of them and returns every failure, for diagnostic purposes.
+// Decoding and signature failures are still fatal and returned alone, and the audience is only
+// checked when all the other claims are valid.
+// The TokenInfo is only returned when there are no failures.
+func (v *GoogleTokenVerifier) VerifyAll(authToken string, aud string) (*TokenInfo, []error) {
+	start := time.Now()
+	tokeninfo, errs := v.verifyAll(authToken, v.audienceMatchMode.expecting(aud))
+	if v.metrics != nil {
+		var err error
+		if len(errs) > 0 {
+			err = errs[0]
+		}
+		v.metrics.OnVerify(verifyResult(err), time.Since(start))
+	}
+	return tokeninfo, errs
+}
+
+func (v *GoogleTokenVerifier) verifyAll(authToken string, audOK audienceMatcher) (*TokenInfo, []error) {
+	ctx, cancel := v.withVerifyTimeout(context.Background())
+	defer cancel()
+	certs, err := v.getCerts(ctx)
+	if err != nil {
+		return nil, []error{err}
+	}
+	return v.verifyLoadedWith(ctx, authToken, certs, func(certs *Certs) (*TokenInfo, []error) {
+		tokeninfo, payload, err := v.verifySignature(authToken, v.certsKeyResolver(certs))
+		if err != nil {
+			return nil, []error{err}
+		}
+		if errs := v.checkClaims(tokeninfo, audOK, v.clock.Now()); len(errs) > 0 {
+			return nil, errs
+		}
+		tokeninfo, err = v.acceptToken(tokeninfo, payload)
+		if err != nil {
+			return nil, []error{err}
+		}
+		return tokeninfo, nil
+	})
+}
+
+// verifySignature decodes authToken and verifies its signature with the keys returned by resolveKey.
+// It returns the decoded claims along with the raw payload.
+func (v *GoogleTokenVerifier) verifySignature(authToken string, resolveKey keyResolver) (*TokenInfo, []byte, error) {
+	token, err := parseToken(authToken, v.lenientBase64)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := v.checkClaimSizes(token.payload); err != nil {
+		return nil, nil, newVerifyError(MalformedToken, err)
+	}
+	if err := token.verifySignature(resolveKey); err != nil {
+		return nil, nil, err
+	}
+	if v.headerType != "" && !sameMediaType(token.header.Typ, v.headerType) {
+		return nil, nil, newVerifyError(InvalidClaims, fmt.Errorf("%w: got %q", ErrHeaderTypeMismatch, token.header.Typ))
+	}
+	return token.tokeninfo, token.payload, nil
+}
+
+// sameMediaType compares typ header values, which are case-insensitive media types whose
+// "application/" prefix can be omitted
+func sameMediaType(a string, b string) bool {
+	trim := func(typ string) string {
+		if len(typ) > len("application/") && strings.EqualFold(typ[:len("application/")], "application/") {
+			return typ[len("application/"):]
+		}
+		return typ
+	}
+	return strings.EqualFold(trim(a), trim(b))
+}
+
+// checkClaims validates the claims of a token whose signature is valid, returning all the failures.
+// The audience is checked last, and only when all the other claims are valid.
+func (v *GoogleTokenVerifier) checkClaims(tokeninfo *TokenInfo, audOK audienceMatcher, now time.Time) []error {
+	var errs []error
+	if !v.acceptedIssuer(tokeninfo.Iss) {
+		errs = append(errs, newVerifyError(InvalidClaims, fmt.Errorf("%w: got %q", ErrIssuerMismatch, tokeninfo.Iss)))
+	}
+	if err := checkTime(tokeninfo, now, v.leeway); err != nil && !v.withinOutageGrace(err, tokeninfo, now) {
+		errs = append(errs, newVerifyError(InvalidClaims, err))
+	}
+	if v.maxAge > 0 && now.Add(-v.maxAge-v.leeway).Unix() > tokeninfo.Iat {
+		errs = append(errs, newVerifyError(InvalidClaims, fmt.Errorf("%w: issued at %d, now is %d", ErrTokenTooOld, tokeninfo.Iat, now.Unix())))
+	}
+	if tokeninfo.Sub == "" {
+		errs = append(errs, newVerifyError(InvalidClaims, ErrMissingSubject))
+	} else if v.numericSubject && !isNumeric(tokeninfo.Sub) {
+		errs = append(errs, newVerifyError(InvalidClaims, fmt.Errorf("%w: got %q", ErrInvalidSubjectFormat, tokeninfo.Sub)))
+	}
+	if !v.acceptedClient(tokeninfo) {
+		errs = append(errs, newVerifyError(InvalidClaims, fmt.Errorf("%w: got aud %q and azp %q", ErrClientIDMismatch, tokeninfo.Aud, tokeninfo.Azp)))
+	}
+	if v.authorizedParty != "" && tokeninfo.Azp != v.authorizedParty {
+		errs = append(errs, newVerifyError(InvalidClaims, fmt.Errorf("%w: got %q", ErrAuthorizedPartyMismatch, tokeninfo.Azp)))
+	}
+	if v.requireNonce && (tokeninfo.Nonce == "" || subtle.ConstantTimeCompare([]byte(tokeninfo.Nonce), []byte(v.expectedNonce)) != 1) {
+		errs = append(errs, newVerifyError(InvalidClaims, fmt.Errorf("%w: got %q", ErrNonceMismatch, tokeninfo.Nonce)))
+	}
+	if v.hostedDomain != "" && !strings.EqualFold(tokeninfo.Hd, v.hostedDomain) {
+		errs = append(errs, newVerifyError(InvalidClaims, fmt.Errorf("%w: got %q", ErrHostedDomainMismatch, tokeninfo.Hd)))
+	}
+	if domain, blocked := v.blockedEmailDomain(tokeninfo); blocked {
+		errs = append(errs, newVerifyError(InvalidClaims, fmt.Errorf("%w: got %q", ErrEmailDomainBlocked, domain)))
+	}
+	if v.requireVerifiedEmail && tokeninfo.Email != "" && !tokeninfo.EmailVerified {
+		errs = append(errs, newVerifyError(InvalidClaims, fmt.Errorf("%w: got %q", ErrEmailNotVerified, tokeninfo.Email)))
+	}
+	// audOK may look the audience up, which is not worth doing for a token failing anyway
+	if len(errs) == 0 && !audOK(tokeninfo.Aud) {
+		errs = append(errs, newVerifyError(InvalidClaims, fmt.Errorf("%w: got %q", ErrAudienceMismatch, tokeninfo.Aud)))
+	}
+	return errs
+}
+
+// withinOutageGrace tells if a token that failed the time check with err is expired by less
+// than the outage grace while the certs provider is serving stale certs
+func (v *GoogleTokenVerifier) withinOutageGrace(err error, tokeninfo *TokenInfo, now time.Time) bool {
+	if v.outageExpiryGrace <= 0 || !errors.Is(err, ErrTokenExpired) {
+		return false
+	}
+	prv, ok := v.certProvider.(*CachedURLCertsProvider)
+	if !ok || !prv.servingStaleCerts() {
+		return false
+	}
+	return now.Add(-v.leeway-v.outageExpiryGrace).Unix() <= tokeninfo.Exp
+}
+
+// acceptedClient does the strict client check: the aud or the azp of the token must be
+// one of the client IDs, and the aud must be when there is no azp
+func (v *GoogleTokenVerifier) acceptedClient(tokeninfo *TokenInfo) bool {
+	if v.strictClientIDs == nil {
+		return true
+	}
+	for _, clientID := range v.strictClientIDs {
+		if tokeninfo.Aud.Contains(clientID) || (tokeninfo.Azp != "" && tokeninfo.Azp == clientID) {
+			return true
+		}
+	}
+	return false
+}
+
+// blockedEmailDomain returns the domain of the verified email of the token when it is blocked
+func (v *GoogleTokenVerifier) blockedEmailDomain(tokeninfo *TokenInfo) (string, bool) {
+	if len(v.blockedEmailDomains) == 0 || !tokeninfo.EmailVerified {
+		return "", false
+	}
+	at := strings.LastIndex(tokeninfo.Email, "@")
+	if at < 0 {
+		return "", false
+	}
+	domain := strings.ToLower(tokeninfo.Email[at+1:])
+	return domain, v.blockedEmailDomains[domain]
+}
+
+func (v *GoogleTokenVerifier) acceptedIssuer(iss string) bool {
+	if !v.caseInsensitiveIssuer {
+		return containsString(v.issuers, iss)
+	}
+	for _, issuer := range v.issuers {
+		if strings.EqualFold(issuer, iss) {
+			return true
+		}
+	}
+	return false
+}
+
+// acceptToken does the last steps once a token is known to be valid
+func (v *GoogleTokenVerifier) acceptToken(tokeninfo *TokenInfo, payload []byte) (*TokenInfo, error) {
+	tokeninfo.platform = platformFor(tokeninfo.Aud, v.clientPlatforms)
+	tokeninfo.clock, tokeninfo.leeway = v.clock, v.leeway
+
+	if v.claimsFactory != nil {
+		custom := v.claimsFactory()
+		if err := json.Unmarshal(payload, custom); err != nil {
+			return nil, newVerifyError(MalformedToken, fmt.Errorf("Token claims could not be decoded into the custom claims: %v", err))
+		}
+		tokeninfo.custom = custom
+	}
+	return tokeninfo, nil
+}
+
+// checkReplay records the jti of a token that passed every other check, so that a token
+// rejected for another reason does not use it up, and rejects it if it was already seen
+func (v *GoogleTokenVerifier) checkReplay(tokeninfo *TokenInfo) error {
+	if v.replayDetector == nil {
+		return nil
+	}
+	if tokeninfo.Jti == "" {
+		return newVerifyError(InvalidClaims, ErrMissingJti)
+	}
+	if v.replayDetector.Seen(tokeninfo.Jti, time.Unix(tokeninfo.Exp, 0)) {
+		return newVerifyError(InvalidClaims, ErrTokenReplayed)
+	}
+	return nil
+}
+
+// acceptReplay completes the verification of paths without the iat skew check: tokeninfo and
+// err are returned as is, unless the token is a replay
+func (v *GoogleTokenVerifier) acceptReplay(tokeninfo *TokenInfo, err error) (*TokenInfo, error) {
+	if err != nil {
+		return nil, err
+	}
+	if err := v.checkReplay(tokeninfo); err != nil {
+		return nil, err
+	}
+	return tokeninfo, nil
 }
 
 func getTokenInfo(bt []byte) (*TokenInfo, error) {
+	var claims map[string]json.RawMessage
+	if err := json.Unmarshal(bt, &claims); err != nil || claims == nil {
+		return nil, fmt.Errorf("%w: payload is not a JSON object", ErrMalformedToken)
+	}
+	if err := validateClaimTypes(claims); err != nil {
+		return nil, err
+	}
 	var a *TokenInfo
 	err := json.Unmarshal(bt, &a)
 	return a, err
 }
 
-func checkTime(tokeninfo *TokenInfo) bool {
-	if (time.Now().Unix() < tokeninfo.Iat) || (time.Now().Unix() > tokeninfo.Exp) {
-		return false
+// validateClaimTypes checks the registered claims have the type expected by the spec,
+// so a malformed token is reported with a descriptive error instead of a decoding one
+func validateClaimTypes(claims map[string]json.RawMessage) error {
+	if raw, ok := claims["aud"]; ok {
+		var aud Audience
+		if err := json.Unmarshal(raw, &aud); err != nil {
+			return fmt.Errorf("%w: claim aud must be a string or an array of strings, got %s", ErrMalformedToken, raw)
+		}
 	}
-	return true
+	if raw, ok := claims["iss"]; ok {
+		var iss string
+		if err := json.Unmarshal(raw, &iss); err != nil {
+			return fmt.Errorf("%w: claim iss must be a string, got %s", ErrMalformedToken, raw)
+		}
+	}
+	for _, name := range []string{"exp", "iat"} {
+		if raw, ok := claims[name]; ok {
+			var num float64
+			if err := json.Unmarshal(raw, &num); err != nil {
+				return fmt.Errorf("%w: claim %s must be a number, got %s", ErrMalformedToken, name, raw)
+			}
+			if num != math.Trunc(num) {
+				return fmt.Errorf("%w: claim %s must be an integer number of seconds, got %s", ErrMalformedToken, name, raw)
+			}
+		}
+	}
+	return nil
+}
+
+// checkClaimSizes rejects the payload if any string claim, nested ones included, is too large
+func (v *GoogleTokenVerifier) checkClaimSizes(payload []byte) error {
+	if v.maxClaimValueBytes <= 0 {
+		return nil
+	}
+	var claims map[string]interface{}
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return wrapSentinel(ErrMalformedToken, err)
+	}
+	for name, value := range claims {
+		if size := largestString(value); size > v.maxClaimValueBytes {
+			return fmt.Errorf("%w: claim %s has %d bytes, the limit is %d", ErrClaimTooLarge, name, size, v.maxClaimValueBytes)
+		}
+	}
+	return nil
+}
+
+// largestString returns the size in bytes of the largest string in value
+func largestString(value interface{}) int {
+	largest := 0
+	switch value := value.(type) {
+	case string:
+		largest = len(value)
+	case []interface{}:
+		for _, item := range value {
+			if size := largestString(item); size > largest {
+				largest = size
+			}
+		}
+	case map[string]interface{}:
+		for key, item := range value {
+			if len(key) > largest {
+				largest = len(key)
+			}
+			if size := largestString(item); size > largest {
+				largest = size
+			}
+		}
+	}
+	return largest
+}
+
+func checkTime(tokeninfo *TokenInfo, now time.Time, leeway time.Duration) error {
+	if now.Add(leeway).Unix() < tokeninfo.Iat {
+		return fmt.Errorf("%w: issued at %d, now is %d", ErrTokenNotYetValid, tokeninfo.Iat, now.Unix())
+	}
+	if now.Add(-leeway).Unix() > tokeninfo.Exp {
+		return fmt.Errorf("%w: expired at %d, now is %d", ErrTokenExpired, tokeninfo.Exp, now.Unix())
+	}
+	return nil
 }
 
-//GetCertsFromURL is
+// GetCertsFromURL downloads the Google certs, returning nil when they cannot be downloaded.
+//
+// Deprecated: use NewCachedURLCertsProvider, which reports why the certs could not be downloaded.
 func GetCertsFromURL() []byte {
-	res, _ := http.Get("https://www.googleapis.com/oauth2/v3/certs")
-	certs, _ := ioutil.ReadAll(res.Body)
-	res.Body.Close()
+	res, err := defaultHTTPClient.Get(GoogleCertsURL)
+	if err != nil {
+		return nil
+	}
+	defer res.Body.Close()
+	certs, err := readCertsBody(res.Body)
+	if err != nil {
+		return nil
+	}
 	return certs
 }
 
-//GetCerts is
+// GetCerts decodes certs and checks they can verify tokens, see DecodeCerts
 func GetCerts(bt []byte) (*Certs, error) {
-	var certs *Certs
-	err := json.Unmarshal(bt, &certs)
-	return certs, err
+	return DecodeCerts(bt)
 }
 
-func urlsafeB64decode(str string) []byte {
-	if m := len(str) % 4; m != 0 {
-		str += strings.Repeat("=", 4-m)
+func containsString(values []string, str string) bool {
+	for _, v := range values {
+		if v == str {
+			return true
+		}
 	}
-	bt, _ := base64.URLEncoding.DecodeString(str)
-	return bt
+	return false
 }
 
-func choiceKeyByKeyID(a []keys, tknkid string) (keys, error) {
-	// TODO: Improve
-	if len(a) == 2 {
-		if a[0].Kid == tknkid {
-			return a[0], nil
-		}
-		if a[1].Kid == tknkid {
-			return a[1], nil
+// isNumeric tells if str is a non empty string of digits
+func isNumeric(str string) bool {
+	if str == "" {
+		return false
+	}
+	for _, c := range str {
+		if c < '0' || c > '9' {
+			return false
 		}
 	}
-	err := errors.New("Token is not valid, kid from token and certificate don't match")
-	var b keys
-	return b, err
+	return true
 }
 
-func getAuthTokenKeyID(bt []byte) (string, error) {
-	var a keys
-	err := json.Unmarshal(bt, &a)
-	return a.Kid, err
+// urlsafeB64decode decodes base64url, with or without padding
+func urlsafeB64decode(str string) ([]byte, error) {
+	return base64.RawURLEncoding.DecodeString(strings.TrimRight(str, "="))
+}
+
+func choiceKeyByKeyID(a []Key, tknkid string) (Key, error) {
+	for _, key := range a {
+		if key.Kid == tknkid {
+			return key, nil
+		}
+	}
+	err := fmt.Errorf("%w: got %q", ErrKeyIDNotFound, tknkid)
+	var b Key
+	return b, err
 }
 
-func divideAuthToken(str string) ([]byte, []byte, []byte, []byte, error) {
-	args := strings.Split(str, ".")
+// divideAuthToken decodes the segments of a token, ignoring the whitespace around it and the padding
+// of its segments, and returns them along with the hash of the message signed
+func divideAuthToken(str string, lenient bool) ([]byte, []byte, []byte, []byte, error) {
+	args := strings.Split(strings.TrimSpace(str), ".")
+	if len(args) != 3 {
+		return []byte{}, []byte{}, []byte{}, []byte{}, fmt.Errorf("%w: expected 3 segments, got %d", ErrMalformedToken, len(args))
+	}
+	for i, arg := range args {
+		// tokens are signed without padding
+		arg = strings.TrimRight(arg, "=")
+		args[i] = arg
+		if arg == "" {
+			return []byte{}, []byte{}, []byte{}, []byte{}, fmt.Errorf("%w: segment %d is empty", ErrMalformedToken, i)
+		}
+	}
+	if lenient {
+		for i := range args {
+			args[i] = toRawURLBase64(args[i])
+		}
+	}
 	sum, err := calcSum(args[0] + "." + args[1])
 	if err != nil {
 		return []byte{}, []byte{}, []byte{}, []byte{}, err
 	}
-	return urlsafeB64decode(args[0]), urlsafeB64decode(args[1]), urlsafeB64decode(args[2]), sum, nil
+	var segments [3][]byte
+	for i := range segments {
+		segments[i], err = urlsafeB64decode(args[i])
+		if err != nil {
+			return []byte{}, []byte{}, []byte{}, []byte{}, fmt.Errorf("%w: segment %d is not base64url encoded: %v", ErrMalformedToken, i, err)
+		}
+	}
+	return segments[0], segments[1], segments[2], sum, nil
 }
 
-func byteToBtr(bt0 []byte) *bytes.Reader {
-	var bt1 []byte
-	if len(bt0) < 8 {
-		bt1 = make([]byte, 8-len(bt0), 8)
-		bt1 = append(bt1, bt0...)
-	} else {
-		bt1 = bt0
-	}
-	return bytes.NewReader(bt1)
+// toRawURLBase64 converts a standard base64 string, with or without padding, to unpadded base64url
+func toRawURLBase64(str string) string {
+	str = strings.NewReplacer("+", "-", "/", "_").Replace(str)
+	return strings.TrimRight(str, "=")
 }
 
 func calcSum(str string) ([]byte, error) {
@@ -170,12 +866,6 @@ func calcSum(str string) ([]byte, error) {
 	return a.Sum(nil), nil
 }
 
-func btrToInt(a io.Reader) int {
-	var e uint64
-	_ = binary.Read(a, binary.BigEndian, &e)
-	return int(e)
-}
-
 func byteToInt(bt []byte) *big.Int {
 	a := big.NewInt(0)
 	a.SetBytes(bt)
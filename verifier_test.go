@@ -1,20 +1,1585 @@
 package GoogleIdTokenVerifier
 
 import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
 
+const testPrivateKeyPath string = "testdata/jwtRS256.key"
+const testKeyID string = "8d2a8ba5c6e5e6a2b2ed4fd1e3a4f0c3b8a1d9e7"
+const testAud string = "XXXXXXXXXXXXXXXXXXXXXXXXXXXXXXX.apps.googleusercontent.com"
+
 func TestCheckToken(t *testing.T) {
-	authToken := "XXXXXXXXXXX.XXXXXXXXXXXX.XXXXXXXXXX"
-	aud := "XXXXXXXXXXXXXXXXXXXXXXXXXXXXXXX.apps.googleusercontent.com"
+	certs := loadTestCerts(t)
+	verifier := New(&StaticCertsProvider{certs: certs})
+	authToken := signTestToken(t, testClaims(time.Now()))
+	actual := verifier.Verify(authToken, testAud)
+	require.NotNil(t, actual)
+	assert.Equal(t, "110169484474386276334", actual.Sub)
+	assert.Equal(t, "testuser@gmail.com", actual.Email)
+
+	assert.Nil(t, verifier.Verify(authToken, "other.apps.googleusercontent.com"))
+	assert.Nil(t, verifier.Verify("XXXXXXXXXXX.XXXXXXXXXXXX.XXXXXXXXXX", testAud))
+}
+
+func TestVerifyAsOf(t *testing.T) {
+	certs := loadTestCerts(t)
+	verifier := New(&StaticCertsProvider{certs: certs})
+	issued := time.Now().Add(-48 * time.Hour)
+	authToken := signTestToken(t, testClaims(issued))
+
+	// the token is expired now
+	assert.Nil(t, verifier.Verify(authToken, testAud))
+
+	// but it was valid back then
+	tokeninfo, err := verifier.VerifyAsOf(authToken, testAud, issued.Add(30*time.Minute), certs)
+	require.NoError(t, err)
+	require.NotNil(t, tokeninfo)
+	assert.Equal(t, "110169484474386276334", tokeninfo.Sub)
+
+	_, err = verifier.VerifyAsOf(authToken, testAud, issued.Add(2*time.Hour), certs)
+	assert.Error(t, err)
+	_, err = verifier.VerifyAsOf(authToken, testAud, issued.Add(-time.Minute), certs)
+	assert.Error(t, err)
+	_, err = verifier.VerifyAsOf(authToken, testAud, issued.Add(30*time.Minute), nil)
+	assert.Error(t, err)
+}
+
+// testClaims returns the claims of a valid Google ID token issued at iat and expiring one hour later
+func testClaims(iat time.Time) map[string]interface{} {
+	return map[string]interface{}{
+		"iss":            "https://accounts.google.com",
+		"azp":            testAud,
+		"aud":            testAud,
+		"sub":            "110169484474386276334",
+		"email":          "testuser@gmail.com",
+		"email_verified": true,
+		"at_hash":        "HK6E_P6Dh8Y93mRNtsDB1Q",
+		"name":           "Test User",
+		"given_name":     "Test",
+		"family_name":    "User",
+		"locale":         "en",
+		"iat":            iat.Unix(),
+		"exp":            iat.Add(time.Hour).Unix(),
+	}
+}
+
+func loadTestPrivateKey(t testing.TB) *rsa.PrivateKey {
+	file, err := ioutil.ReadFile(testPrivateKeyPath)
+	require.NoError(t, err)
+	block, _ := pem.Decode(file)
+	require.NotNil(t, block)
+	key, err := x509.ParsePKCS1PrivateKey(block.Bytes)
+	require.NoError(t, err)
+	return key
+}
+
+// loadTestCerts returns the certs of testdata/certs.json where the first key is
+// replaced by the public key of testdata/jwtRS256.key, so tokens signed by
+// signTestToken can be verified against them.
+func loadTestCerts(t testing.TB) *Certs {
 	staticProvider := NewStaticCertsProvider()
-	err := staticProvider.LoadFromFile(testCertsPath)
+	require.NoError(t, staticProvider.LoadFromFile(testCertsPath))
+	certs, err := staticProvider.GetCerts()
+	require.NoError(t, err)
+	pub := loadTestPrivateKey(t).PublicKey
+	certs.Keys[0] = Key{
+		Kty: "RSA",
+		Alg: "RS256",
+		Use: "sig",
+		Kid: testKeyID,
+		N:   base64.RawURLEncoding.EncodeToString(pub.N.Bytes()),
+		E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes()),
+	}
+	return certs
+}
+
+func signTestToken(t testing.TB, claims map[string]interface{}) string {
+	return signTestTokenWithHeader(t, map[string]interface{}{"alg": "RS256", "kid": testKeyID, "typ": "JWT"}, claims)
+}
+
+func signTestTokenWithHeader(t testing.TB, header map[string]interface{}, claims map[string]interface{}) string {
+	bHeader, err := json.Marshal(header)
+	require.NoError(t, err)
+	bClaims, err := json.Marshal(claims)
+	require.NoError(t, err)
+	messageToSign := base64.RawURLEncoding.EncodeToString(bHeader) + "." + base64.RawURLEncoding.EncodeToString(bClaims)
+	sum := sha256.Sum256([]byte(messageToSign))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, loadTestPrivateKey(t), crypto.SHA256, sum[:])
+	require.NoError(t, err)
+	return messageToSign + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func TestAudienceMatchMode(t *testing.T) {
+	const otherAud string = "other.apps.googleusercontent.com"
+	certs := loadTestCerts(t)
+
+	tests := []struct {
+		testName   string
+		mode       AudienceMatchMode
+		tokenAud   interface{}
+		auds       []string // nil for testAud
+		expSuccess bool
+	}{
+		{"Any match, single audience", AnyMatch, testAud, nil, true},
+		{"Any match, array with the audience", AnyMatch, []string{otherAud, testAud}, nil, true},
+		{"Any match, array without the audience", AnyMatch, []string{otherAud}, nil, false},
+		{"Any match, other single audience", AnyMatch, otherAud, nil, false},
+		{"Exact set match, single audience", ExactSetMatch, testAud, nil, true},
+		{"Exact set match, array with only the audience", ExactSetMatch, []string{testAud}, nil, true},
+		{"Exact set match, array with extra audiences", ExactSetMatch, []string{otherAud, testAud}, nil, false},
+		{"Exact set match, other single audience", ExactSetMatch, otherAud, nil, false},
+		{"Exact set match, no audience", ExactSetMatch, []string{}, []string{}, false},
+		{"Exact set match, no aud claim", ExactSetMatch, nil, []string{}, false},
+		{"Any match, no aud claim", AnyMatch, nil, nil, false},
+	}
+
+	for _, tc := range tests {
+		// nolint
+		t.Run(tc.testName, func(t *testing.T) {
+			verifier := New(&StaticCertsProvider{certs: certs}, WithAudienceMatchMode(tc.mode))
+			claims := testClaims(time.Now())
+			claims["aud"] = tc.tokenAud
+			if tc.tokenAud == nil {
+				delete(claims, "aud")
+			}
+			auds := tc.auds
+			if auds == nil {
+				auds = []string{testAud}
+			}
+			tokeninfo, _ := verifier.VerifyMulti(signTestToken(t, claims), auds)
+			if tc.expSuccess {
+				require.NotNil(t, tokeninfo)
+				assert.True(t, tokeninfo.Aud.Contains(testAud))
+			} else {
+				assert.Nil(t, tokeninfo)
+			}
+		})
+	}
+}
+
+// memoryReplayDetector is a minimal in-memory ReplayDetector
+type memoryReplayDetector struct {
+	mutex sync.Mutex
+	seen  map[string]time.Time
+}
+
+func (d *memoryReplayDetector) Seen(jti string, exp time.Time) bool {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	if _, ok := d.seen[jti]; ok {
+		return true
+	}
+	d.seen[jti] = exp
+	return false
+}
+
+func TestReplayDetector(t *testing.T) {
+	detector := &memoryReplayDetector{seen: map[string]time.Time{}}
+	verifier := New(&StaticCertsProvider{certs: loadTestCerts(t)}, WithReplayDetector(detector))
+
+	claims := testClaims(time.Now())
+	claims["jti"] = "f2b0c4d1e6a74b8e9c3d5a7f1e2b4c6d"
+	authToken := signTestToken(t, claims)
+
+	tokeninfo := verifier.Verify(authToken, testAud)
+	require.NotNil(t, tokeninfo)
+	assert.Equal(t, "f2b0c4d1e6a74b8e9c3d5a7f1e2b4c6d", tokeninfo.Jti)
+	_, err := verifier.VerifyE(authToken, testAud)
+	assert.True(t, errors.Is(err, ErrTokenReplayed), "replayed token must be rejected, got %v", err)
+
+	claims["jti"] = "0a1b2c3d4e5f60718293a4b5c6d7e8f9"
+	assert.NotNil(t, verifier.Verify(signTestToken(t, claims), testAud))
+
+	delete(claims, "jti")
+	_, err = verifier.VerifyE(signTestToken(t, claims), testAud)
+	assert.True(t, errors.Is(err, ErrMissingJti), "token without jti must be rejected, got %v", err)
+
+	// a token rejected as an anomaly does not use its jti up
+	certs := loadTestCerts(t)
+	certProv, err := NewCachedURLCertsProviderWithOptions(WithCertsFetcher(func(ctx context.Context) (*Certs, time.Time, error) {
+		return certs, time.Now().Add(2 * time.Hour), nil
+	}))
+	require.NoError(t, err)
+	verifier = New(certProv, WithReplayDetector(detector), WithIatCertsSkewCheck(10*time.Minute), WithRejectAnomalies())
+	claims = testClaims(time.Now().Add(-50 * time.Minute))
+	claims["jti"] = "9f8e7d6c5b4a39281706f5e4d3c2b1a0"
+	oldToken := signTestToken(t, claims)
+	for i := 0; i < 2; i++ {
+		_, err = verifier.VerifyE(oldToken, testAud)
+		assert.True(t, errors.Is(err, ErrAnomaly), "got %v", err)
+	}
+}
+
+func TestMalformedClaimTypes(t *testing.T) {
+	certs := loadTestCerts(t)
+	verifier := New(&StaticCertsProvider{certs: certs})
+	now := time.Now()
+
+	tests := []struct {
+		testName string
+		claim    string
+		value    interface{}
+		expError string
+	}{
+		{"Numeric aud", "aud", 12345, "claim aud must be a string or an array of strings"},
+		{"Array of numbers aud", "aud", []int{1, 2}, "claim aud must be a string or an array of strings"},
+		{"Array iss", "iss", []string{"https://accounts.google.com"}, "claim iss must be a string"},
+		{"String exp", "exp", "1600000000", "claim exp must be a number"},
+		{"Boolean iat", "iat", true, "claim iat must be a number"},
+		{"Fractional exp", "exp", 1600000000.5, "claim exp must be an integer number of seconds"},
+	}
+
+	for _, tc := range tests {
+		// nolint
+		t.Run(tc.testName, func(t *testing.T) {
+			claims := testClaims(now)
+			claims[tc.claim] = tc.value
+			tokeninfo, err := verifier.VerifyAsOf(signTestToken(t, claims), testAud, now, certs)
+			assert.Nil(t, tokeninfo)
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tc.expError)
+		})
+	}
+
+	_, err := getTokenInfo([]byte("null"))
+	assert.Error(t, err)
+	_, err = getTokenInfo([]byte(`["not", "an", "object"]`))
+	assert.Error(t, err)
+}
+
+func TestVerifyToMap(t *testing.T) {
+	verifier := New(&StaticCertsProvider{certs: loadTestCerts(t)})
+	now := time.Now()
+
+	first := testClaims(now)
+	first["email"] = "first@gmail.com"
+	second := testClaims(now)
+	second["sub"] = "208426113748299532117"
+	duplicated := testClaims(now)
+	duplicated["email"] = "duplicated@gmail.com"
+	expired := testClaims(now.Add(-2 * time.Hour))
+
+	verified, errs := verifier.VerifyToMap([]string{
+		signTestToken(t, first),
+		"not.a.token",
+		signTestToken(t, second),
+		signTestToken(t, expired),
+		signTestToken(t, duplicated),
+	}, testAud)
+
+	require.Len(t, verified, 2)
+	assert.Equal(t, "duplicated@gmail.com", verified["110169484474386276334"].Email)
+	assert.Equal(t, "testuser@gmail.com", verified["208426113748299532117"].Email)
+	require.Len(t, errs, 2)
+	assert.Contains(t, errs[0].Error(), "token 1")
+	assert.Contains(t, errs[1].Error(), "token 3")
+}
+
+func TestVerifyBatch(t *testing.T) {
+	var requestCount int32
+	ts := httptest.NewServer(getTestCertsHandlerFunc(t, 2*time.Hour, &requestCount))
+	defer ts.Close()
+	verifier := New(createDynamicCertProvider(ts.URL, defaultRefreshBefore))
+	now := time.Now()
+
+	tokens := make([]string, 20)
+	for i := range tokens {
+		claims := testClaims(now)
+		claims["sub"] = fmt.Sprint(1000 + i)
+		tokens[i] = signTestToken(t, claims)
+	}
+	tokens[3] = "not.a.token"
+	tokens[7] = signTestToken(t, testClaims(now.Add(-2*time.Hour)))
+
+	results := verifier.VerifyBatch(context.Background(), tokens, testAud)
+	require.Len(t, results, len(tokens))
+	for i, result := range results {
+		switch i {
+		case 3:
+			assert.Nil(t, result.TokenInfo)
+			assert.ErrorIs(t, result.Err, ErrMalformedToken)
+		case 7:
+			assert.Nil(t, result.TokenInfo)
+			assert.ErrorIs(t, result.Err, ErrTokenExpired)
+		default:
+			require.NoError(t, result.Err)
+			assert.Equal(t, fmt.Sprint(1000+i), result.TokenInfo.Sub)
+		}
+	}
+	// the certs are fetched once for the whole batch
+	assert.EqualValues(t, 1, atomic.LoadInt32(&requestCount))
+
+	assert.Empty(t, verifier.VerifyBatch(context.Background(), nil, testAud))
+
+	// every token fails when there are no certs
+	results = New(failingCertsProvider{}).VerifyBatch(context.Background(), tokens[:2], testAud)
+	require.Len(t, results, 2)
+	for _, result := range results {
+		assert.Nil(t, result.TokenInfo)
+		assert.ErrorIs(t, result.Err, ErrCertsUnavailable)
+	}
+
+	// the tokens left fail once the batch is cancelled
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	cancelling := New(&StaticCertsProvider{certs: loadTestCerts(t)}, WithMetrics(cancellingMetrics(cancel)))
+	tokens = make([]string, 2*runtime.GOMAXPROCS(0)+4)
+	for i := range tokens {
+		tokens[i] = signTestToken(t, testClaims(now))
+	}
+	results = cancelling.VerifyBatch(ctx, tokens, testAud)
+	require.Len(t, results, len(tokens))
+	cancelled := 0
+	for _, result := range results {
+		if result.Err != nil {
+			assert.ErrorIs(t, result.Err, context.Canceled)
+			cancelled++
+		}
+	}
+	assert.GreaterOrEqual(t, cancelled, len(tokens)-runtime.GOMAXPROCS(0)-2)
+	assert.ErrorIs(t, results[len(results)-1].Err, context.Canceled)
+}
+
+// cancellingMetrics calls cancel after the first verification
+type cancellingMetrics context.CancelFunc
+
+func (m cancellingMetrics) OnVerify(result string, dur time.Duration) {
+	m()
+}
+
+func (m cancellingMetrics) OnCertRefresh(success bool, dur time.Duration, fromCache bool) {}
+
+func TestUnknownKeyRefresh(t *testing.T) {
+	fileCerts, err := ioutil.ReadFile(testCertsPath)
+	require.NoError(t, err)
+	// the first certs do not have the key of the test tokens yet
+	beforeRotation := func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Expires", time.Now().Add(2*time.Hour).UTC().Format(http.TimeFormat))
+		_, _ = w.Write(fileCerts)
+	}
+	var requestCount int32
+	ts := httptest.NewServer(appendHandlerFunc(beforeRotation, getTestCertsHandlerFunc(t, 2*time.Hour, nil), &requestCount))
+	defer ts.Close()
+	prv := createDynamicCertProvider(ts.URL, defaultRefreshBefore)
+	defer prv.Close()
+	verifier := New(prv)
+
+	tokeninfo, err := verifier.VerifyE(signTestToken(t, testClaims(time.Now())), testAud)
+	require.NoError(t, err, "the certs should be refreshed for the new key")
+	assert.NotNil(t, tokeninfo)
+	assert.EqualValues(t, 2, atomic.LoadInt32(&requestCount))
+
+	// tokens with bogus kids do not make the provider hammer the certs URL
+	bogus := signTestTokenWithHeader(t, map[string]interface{}{"alg": "RS256", "kid": "bogus", "typ": "JWT"}, testClaims(time.Now()))
+	for i := 0; i < 10; i++ {
+		_, err = verifier.VerifyE(bogus, testAud)
+		assert.True(t, errors.Is(err, ErrKeyIDNotFound), "got %v", err)
+	}
+	assert.EqualValues(t, 2, atomic.LoadInt32(&requestCount))
+
+	// until the refresh interval has elapsed
+	prv.mutex.Lock()
+	prv.unknownKeyRefreshAt = time.Now().Add(-prv.unknownKeyRefreshInterval)
+	prv.mutex.Unlock()
+	_, err = verifier.VerifyE(bogus, testAud)
+	assert.True(t, errors.Is(err, ErrKeyIDNotFound), "got %v", err)
+	assert.EqualValues(t, 3, atomic.LoadInt32(&requestCount))
+
+	// other providers are not refreshed
+	_, err = New(&StaticCertsProvider{certs: loadTestCerts(t)}).VerifyE(bogus, testAud)
+	assert.True(t, errors.Is(err, ErrKeyIDNotFound), "got %v", err)
+}
+
+func TestUnknownKeyRefreshTimeout(t *testing.T) {
+	fileCerts, err := ioutil.ReadFile(testCertsPath)
 	require.NoError(t, err)
-	verifier := New(staticProvider)
-	actual := verifier.Verify(authToken, aud)
-	assert.NotNil(t, actual)
-	// TODO: do a proper test of a token verification
+	var requestCount int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch incrementAndGet(&requestCount) {
+		case 1:
+			// the first certs do not have the key of the test tokens yet
+			w.Header().Set("Expires", time.Now().Add(2*time.Hour).UTC().Format(http.TimeFormat))
+			_, _ = w.Write(fileCerts)
+		case 2:
+			time.Sleep(500 * time.Millisecond)
+			getTestCertsHandlerFunc(t, 2*time.Hour, nil)(w, r)
+		default:
+			getTestCertsHandlerFunc(t, 2*time.Hour, nil)(w, r)
+		}
+	}))
+	defer ts.Close()
+	prv := createDynamicCertProvider(ts.URL, defaultRefreshBefore)
+	defer prv.Close()
+	verifier := New(prv, WithVerifyTimeout(50*time.Millisecond))
+	authToken := signTestToken(t, testClaims(time.Now()))
+
+	// the refresh for the new key is bounded by the verify timeout
+	start := time.Now()
+	_, err = verifier.VerifyE(authToken, testAud)
+	assert.Less(t, int64(time.Since(start)), int64(400*time.Millisecond))
+	assert.True(t, errors.Is(err, ErrKeyIDNotFound), "got %v", err)
+
+	// a refresh given up does not count against the refresh interval
+	prv.mutex.Lock()
+	assert.True(t, prv.unknownKeyRefreshAt.IsZero())
+	prv.mutex.Unlock()
+
+	// while the download goes on for the next verifications
+	assert.Eventually(t, func() bool {
+		_, err := verifier.VerifyE(authToken, testAud)
+		return err == nil
+	}, time.Second, 50*time.Millisecond)
+	assert.EqualValues(t, 2, atomic.LoadInt32(&requestCount))
+}
+
+func TestVerifyWithJWK(t *testing.T) {
+	certs := loadTestCerts(t)
+	verifier := New(NewStaticCertsProvider())
+	authToken := signTestToken(t, testClaims(time.Now()))
+
+	tokeninfo, err := verifier.VerifyWithJWK(authToken, testAud, certs.Keys[0])
+	require.NoError(t, err)
+	require.NotNil(t, tokeninfo)
+	assert.Equal(t, "110169484474386276334", tokeninfo.Sub)
+
+	// the kid is not used to pick the key
+	jwk := certs.Keys[0]
+	jwk.Kid = "rotating-key"
+	tokeninfo, err = verifier.VerifyWithJWK(authToken, testAud, jwk)
+	require.NoError(t, err)
+	assert.NotNil(t, tokeninfo)
+
+	tokeninfo, err = verifier.VerifyWithJWK(authToken, testAud, certs.Keys[1])
+	assert.Error(t, err)
+	assert.Nil(t, tokeninfo)
+}
+
+func TestVerifyWithJWKS(t *testing.T) {
+	googleJWKS, err := ioutil.ReadFile(testCertsPath)
+	require.NoError(t, err)
+	testJWKS, err := json.Marshal(loadTestCerts(t))
+	require.NoError(t, err)
+	// the provider is not used
+	verifier := New(failingCertsProvider{})
+	authToken := signTestToken(t, testClaims(time.Now()))
+
+	tokeninfo, err := verifier.VerifyWithJWKS(authToken, testAud, testJWKS)
+	require.NoError(t, err)
+	require.NotNil(t, tokeninfo)
+	assert.Equal(t, "110169484474386276334", tokeninfo.Sub)
+
+	_, err = verifier.VerifyWithJWKS(authToken, "other.apps.googleusercontent.com", testJWKS)
+	assert.True(t, errors.Is(err, ErrAudienceMismatch), "got %v", err)
+
+	// the Google certs do not have the test key
+	_, err = verifier.VerifyWithJWKS(authToken, testAud, googleJWKS)
+	assert.True(t, errors.Is(err, ErrKeyIDNotFound), "got %v", err)
+
+	_, err = verifier.VerifyWithJWKS(authToken, testAud, []byte(`{"keys": []}`))
+	assert.True(t, errors.Is(err, ErrInvalidCerts), "got %v", err)
+	assert.True(t, errors.Is(err, ErrCertsUnavailable), "got %v", err)
+}
+
+func TestTrialVerification(t *testing.T) {
+	certs := loadTestCerts(t)
+	wrongKid := signTestTokenWithHeader(t, map[string]interface{}{"alg": "RS256", "kid": "unknown-kid"}, testClaims(time.Now()))
+	noKid := signTestTokenWithHeader(t, map[string]interface{}{"alg": "RS256"}, testClaims(time.Now()))
+
+	verifier := New(&StaticCertsProvider{certs: certs})
+	assert.Nil(t, verifier.Verify(wrongKid, testAud))
+	assert.Nil(t, verifier.Verify(noKid, testAud))
+
+	verifier = New(&StaticCertsProvider{certs: certs}, WithTrialVerification(2))
+	assert.NotNil(t, verifier.Verify(wrongKid, testAud))
+	assert.NotNil(t, verifier.Verify(noKid, testAud))
+
+	// too many keys to try them all
+	verifier = New(&StaticCertsProvider{certs: certs}, WithTrialVerification(1))
+	assert.Nil(t, verifier.Verify(wrongKid, testAud))
+
+	// none of the keys validates the token
+	otherCerts := &Certs{Keys: []Key{certs.Keys[1], certs.Keys[1]}}
+	verifier = New(&StaticCertsProvider{certs: otherCerts}, WithTrialVerification(2))
+	assert.Nil(t, verifier.Verify(wrongKid, testAud))
+}
+
+func TestLenientBase64(t *testing.T) {
+	certs := loadTestCerts(t)
+	var authToken string
+	// make sure the token has characters that differ between base64 and base64url
+	for !strings.ContainsAny(toStdBase64(t, authToken), "+/") {
+		claims := testClaims(time.Now())
+		claims["nonce"] = time.Now().String()
+		authToken = signTestToken(t, claims)
+	}
+	stdToken := toStdBase64(t, authToken)
+
+	strict := New(&StaticCertsProvider{certs: certs})
+	assert.NotNil(t, strict.Verify(authToken, testAud))
+	assert.Nil(t, strict.Verify(stdToken, testAud))
+
+	lenient := New(&StaticCertsProvider{certs: certs}, WithLenientBase64())
+	assert.NotNil(t, lenient.Verify(authToken, testAud))
+	assert.NotNil(t, lenient.Verify(stdToken, testAud))
+}
+
+func TestTokenNormalization(t *testing.T) {
+	verifier := New(&StaticCertsProvider{certs: loadTestCerts(t)})
+	var authToken string
+	// make sure some segments need padding
+	for toPaddedBase64URL(t, authToken) == authToken {
+		claims := testClaims(time.Now())
+		claims["nonce"] = time.Now().String()
+		authToken = signTestToken(t, claims)
+	}
+
+	tests := []struct {
+		testName  string
+		authToken string
+		expValid  bool
+	}{
+		{"Token", authToken, true},
+		{"Trailing newline", authToken + "\n", true},
+		{"Surrounding whitespace", " \t" + authToken + "\r\n", true},
+		{"Padded segments", toPaddedBase64URL(t, authToken), true},
+		{"Padded segments with trailing newline", toPaddedBase64URL(t, authToken) + "\n", true},
+		{"Whitespace inside", strings.Replace(authToken, ".", ". ", 1), false},
+		{"Padding only segment", "==." + strings.SplitN(authToken, ".", 2)[1], false},
+	}
+
+	for _, tc := range tests {
+		// nolint
+		t.Run(tc.testName, func(t *testing.T) {
+			tokeninfo, err := verifier.VerifyE(tc.authToken, testAud)
+			if tc.expValid {
+				require.NoError(t, err)
+				assert.Equal(t, "110169484474386276334", tokeninfo.Sub)
+				return
+			}
+			assert.Nil(t, tokeninfo)
+			assert.True(t, errors.Is(err, ErrMalformedToken), "got %v", err)
+		})
+	}
+}
+
+// toPaddedBase64URL re-encodes every segment of authToken with padded base64url
+func toPaddedBase64URL(t *testing.T, authToken string) string {
+	if authToken == "" {
+		return ""
+	}
+	segments := strings.Split(authToken, ".")
+	for i, segment := range segments {
+		decoded, err := base64.RawURLEncoding.DecodeString(segment)
+		require.NoError(t, err)
+		segments[i] = base64.URLEncoding.EncodeToString(decoded)
+	}
+	return strings.Join(segments, ".")
+}
+
+// toStdBase64 re-encodes every segment of authToken with padded standard base64
+func toStdBase64(t *testing.T, authToken string) string {
+	if authToken == "" {
+		return ""
+	}
+	segments := strings.Split(authToken, ".")
+	for i, segment := range segments {
+		decoded, err := base64.RawURLEncoding.DecodeString(segment)
+		require.NoError(t, err)
+		segments[i] = base64.StdEncoding.EncodeToString(decoded)
+	}
+	return strings.Join(segments, ".")
+}
+
+func TestVerifyTimeout(t *testing.T) {
+	slowCerts := func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(500 * time.Millisecond)
+		getTestCertsHandlerFunc(t, time.Hour*2, nil)(w, r)
+	}
+	authToken := signTestToken(t, testClaims(time.Now()))
+
+	// First request is 200 and already expired. After that, slow responses
+	ts := httptest.NewServer(appendHandlerFunc(getTestCertsHandlerFunc(t, -time.Minute, nil), slowCerts, new(int32)))
+	defer ts.Close()
+	verifier := New(createDynamicCertProvider(ts.URL, defaultRefreshBefore, WithStaleGracePeriod(time.Hour)), WithVerifyTimeout(50*time.Millisecond))
+	start := time.Now()
+	tokeninfo, err := verifier.VerifyContext(context.Background(), authToken, testAud)
+	assert.Less(t, int64(time.Since(start)), int64(400*time.Millisecond))
+	require.NoError(t, err, "stale certs should be used")
+	assert.NotNil(t, tokeninfo)
+
+	// without stale grace period, expired certs are not used
+	ts1 := httptest.NewServer(appendHandlerFunc(getTestCertsHandlerFunc(t, -time.Minute, nil), slowCerts, new(int32)))
+	defer ts1.Close()
+	verifier = New(createDynamicCertProvider(ts1.URL, defaultRefreshBefore), WithVerifyTimeout(50*time.Millisecond))
+	start = time.Now()
+	tokeninfo, err = verifier.VerifyContext(context.Background(), authToken, testAud)
+	assert.Less(t, int64(time.Since(start)), int64(400*time.Millisecond))
+	assert.True(t, errors.Is(err, ErrVerifyTimeout), "got %v", err)
+	assert.Nil(t, tokeninfo)
+
+	// First request is 500. After that, slow responses
+	ts2 := httptest.NewServer(appendHandlerFunc(getHandlerFunc(http.StatusInternalServerError, 0, nil), slowCerts, new(int32)))
+	defer ts2.Close()
+	verifier = New(createDynamicCertProvider(ts2.URL, defaultRefreshBefore), WithVerifyTimeout(50*time.Millisecond))
+	start = time.Now()
+	tokeninfo, err = verifier.VerifyContext(context.Background(), authToken, testAud)
+	assert.Less(t, int64(time.Since(start)), int64(400*time.Millisecond))
+	assert.True(t, errors.Is(err, ErrVerifyTimeout))
+	assert.Nil(t, tokeninfo)
+	assert.Nil(t, verifier.Verify(authToken, testAud))
+}
+
+type customClaims struct {
+	Subject string   `json:"sub"`
+	Email   string   `json:"email"`
+	Roles   []string `json:"roles"`
+}
+
+func TestClaimsFactory(t *testing.T) {
+	certs := loadTestCerts(t)
+	claims := testClaims(time.Now())
+	claims["roles"] = []string{"admin", "reviewer"}
+	authToken := signTestToken(t, claims)
+
+	verifier := New(&StaticCertsProvider{certs: certs})
+	tokeninfo := verifier.Verify(authToken, testAud)
+	require.NotNil(t, tokeninfo)
+	assert.Nil(t, tokeninfo.CustomClaims())
+
+	verifier = New(&StaticCertsProvider{certs: certs}, WithClaimsFactory(func() interface{} {
+		return &customClaims{}
+	}))
+	tokeninfo = verifier.Verify(authToken, testAud)
+	require.NotNil(t, tokeninfo)
+	custom, ok := tokeninfo.CustomClaims().(*customClaims)
+	require.True(t, ok)
+	assert.Equal(t, "110169484474386276334", custom.Subject)
+	assert.Equal(t, "testuser@gmail.com", custom.Email)
+	assert.Equal(t, []string{"admin", "reviewer"}, custom.Roles)
+
+	// invalid tokens are never decoded
+	decoded := false
+	verifier = New(&StaticCertsProvider{certs: certs}, WithClaimsFactory(func() interface{} {
+		decoded = true
+		return &customClaims{}
+	}))
+	assert.Nil(t, verifier.Verify(authToken, "other.apps.googleusercontent.com"))
+	assert.False(t, decoded)
+}
+
+func TestVerifyAll(t *testing.T) {
+	verifier := New(&StaticCertsProvider{certs: loadTestCerts(t)})
+
+	tokeninfo, errs := verifier.VerifyAll(signTestToken(t, testClaims(time.Now())), testAud)
+	assert.Empty(t, errs)
+	assert.NotNil(t, tokeninfo)
+
+	// expired, wrong audience and wrong issuer at once, the audience is only checked last
+	claims := testClaims(time.Now().Add(-2 * time.Hour))
+	claims["aud"] = "other.apps.googleusercontent.com"
+	claims["iss"] = "https://evil.com"
+	tokeninfo, errs = verifier.VerifyAll(signTestToken(t, claims), testAud)
+	assert.Nil(t, tokeninfo)
+	require.Len(t, errs, 2)
+	assert.Contains(t, errs[0].Error(), "ISS")
+	assert.Contains(t, errs[1].Error(), "expired")
+
+	tokeninfo, errs = verifier.VerifyAll(signTestToken(t, testClaims(time.Now())), "other.apps.googleusercontent.com")
+	assert.Nil(t, tokeninfo)
+	require.Len(t, errs, 1)
+	assert.Contains(t, errs[0].Error(), "Audience")
+
+	// a wrong signature is fatal
+	tampered := signTestToken(t, claims)
+	tampered = tampered[:len(tampered)-4] + "AAAA"
+	tokeninfo, errs = verifier.VerifyAll(tampered, testAud)
+	assert.Nil(t, tokeninfo)
+	assert.Len(t, errs, 1)
+}
+
+func TestCaseInsensitiveIssuer(t *testing.T) {
+	certs := loadTestCerts(t)
+	claims := testClaims(time.Now())
+	claims["iss"] = "HTTPS://Accounts.Google.com"
+	upperToken := signTestToken(t, claims)
+	claims["iss"] = "https://accounts.google.com.evil.com"
+	otherToken := signTestToken(t, claims)
+
+	strict := New(&StaticCertsProvider{certs: certs})
+	assert.Nil(t, strict.Verify(upperToken, testAud))
+	assert.Nil(t, strict.Verify(otherToken, testAud))
+
+	lenient := New(&StaticCertsProvider{certs: certs}, WithCaseInsensitiveIssuer())
+	assert.NotNil(t, lenient.Verify(upperToken, testAud))
+	assert.Nil(t, lenient.Verify(otherToken, testAud))
+}
+
+func TestIssuers(t *testing.T) {
+	certs := loadTestCerts(t)
+	claims := testClaims(time.Now())
+	claims["iss"] = "https://securetoken.google.com/my-project"
+	claims["aud"] = "my-project"
+	firebaseToken := signTestToken(t, claims)
+	googleToken := signTestToken(t, testClaims(time.Now()))
+
+	google := New(&StaticCertsProvider{certs: certs})
+	_, err := google.VerifyE(firebaseToken, "my-project")
+	assert.True(t, errors.Is(err, ErrIssuerMismatch), "got %v", err)
+
+	firebase := New(&StaticCertsProvider{certs: certs}, WithIssuers(FirebaseIssuer("my-project")))
+	tokeninfo, err := firebase.VerifyE(firebaseToken, "my-project")
+	require.NoError(t, err)
+	assert.Equal(t, "https://securetoken.google.com/my-project", tokeninfo.Iss)
+	_, err = firebase.VerifyE(googleToken, testAud)
+	assert.True(t, errors.Is(err, ErrIssuerMismatch), "got %v", err)
+
+	both := New(&StaticCertsProvider{certs: certs}, WithIssuers(append(googleIssuers, FirebaseIssuer("my-project"))...))
+	_, err = both.VerifyE(firebaseToken, "my-project")
+	assert.NoError(t, err)
+	_, err = both.VerifyE(googleToken, testAud)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"accounts.google.com", "https://accounts.google.com"}, googleIssuers)
+}
+
+func TestClientPlatforms(t *testing.T) {
+	const androidAud string = "YYYYYYYYYYYYYYYYYYYYYYYYYYYYYYY.apps.googleusercontent.com"
+	const otherAud string = "other.apps.googleusercontent.com"
+	verifier := New(&StaticCertsProvider{certs: loadTestCerts(t)}, WithClientPlatforms(map[string]Platform{
+		testAud:    Web,
+		androidAud: Android,
+	}))
+
+	claims := testClaims(time.Now())
+	tokeninfo := verifier.Verify(signTestToken(t, claims), testAud)
+	require.NotNil(t, tokeninfo)
+	assert.Equal(t, Web, tokeninfo.Platform())
+
+	claims["aud"] = androidAud
+	tokeninfo = verifier.Verify(signTestToken(t, claims), androidAud)
+	require.NotNil(t, tokeninfo)
+	assert.Equal(t, Android, tokeninfo.Platform())
+	assert.Equal(t, "android", tokeninfo.Platform().String())
+
+	claims["aud"] = otherAud
+	tokeninfo = verifier.Verify(signTestToken(t, claims), otherAud)
+	require.NotNil(t, tokeninfo)
+	assert.Equal(t, UnknownPlatform, tokeninfo.Platform())
+}
+
+// failingCertsProvider never has certs
+type failingCertsProvider struct{}
+
+func (failingCertsProvider) GetCerts() (*Certs, error) {
+	return nil, errors.New("certs are down")
+}
+
+func (prv failingCertsProvider) GetCertsContext(ctx context.Context) (*Certs, error) {
+	return prv.GetCerts()
+}
+
+func TestVerifyE(t *testing.T) {
+	verifier := New(&StaticCertsProvider{certs: loadTestCerts(t)})
+	authToken := signTestToken(t, testClaims(time.Now()))
+	tokeninfo, err := verifier.VerifyE(authToken, testAud)
+	require.NoError(t, err)
+	assert.NotNil(t, tokeninfo)
+
+	otherSub := testClaims(time.Now())
+	otherSub["sub"] = "208426113748299532117"
+	tampered := strings.Split(signTestToken(t, otherSub), ".")
+	wrongAud := testClaims(time.Now())
+	wrongAud["aud"] = "other.apps.googleusercontent.com"
+
+	tests := []struct {
+		testName string
+		verifier *GoogleTokenVerifier
+		token    string
+		expKind  ErrorKind
+	}{
+		{"Malformed token", verifier, "XXXXXXXXXXX.XXXXXXXXXXXX.XXXXXXXXXX", MalformedToken},
+		{"Invalid signature", verifier, tampered[0] + "." + strings.Split(authToken, ".")[1] + "." + tampered[2], InvalidSignature},
+		{"Unknown kid", verifier, signTestTokenWithHeader(t, map[string]interface{}{"alg": "RS256", "kid": "unknown"}, testClaims(time.Now())), InvalidSignature},
+		{"Audience mismatch", verifier, signTestToken(t, wrongAud), InvalidClaims},
+		{"Expired", verifier, signTestToken(t, testClaims(time.Now().Add(-2*time.Hour))), InvalidClaims},
+		{"Certs unavailable", New(failingCertsProvider{}), authToken, CertsUnavailable},
+	}
+
+	for _, tc := range tests {
+		// nolint
+		t.Run(tc.testName, func(t *testing.T) {
+			tokeninfo, err := tc.verifier.VerifyE(tc.token, testAud)
+			assert.Nil(t, tokeninfo)
+			var verr *VerifyError
+			require.True(t, errors.As(err, &verr))
+			assert.Equal(t, tc.expKind, verr.Kind)
+			assert.Equal(t, tc.expKind == CertsUnavailable, errors.Is(err, ErrCertsUnavailable), "got %v", err)
+			assert.Nil(t, tc.verifier.Verify(tc.token, testAud))
+		})
+	}
+}
+
+func TestCertsUnavailableColdStart(t *testing.T) {
+	// Google cannot be reached when the service starts
+	ts := httptest.NewServer(http.NotFoundHandler())
+	ts.Close()
+	verifier := New(createDynamicCertProvider(ts.URL, defaultRefreshBefore))
+
+	_, err := verifier.VerifyE(signTestToken(t, testClaims(time.Now())), testAud)
+	assert.True(t, errors.Is(err, ErrCertsUnavailable), "got %v", err)
+	_, err = verifier.VerifyE("XXXXXXXXXXX.XXXXXXXXXXXX.XXXXXXXXXX", testAud)
+	assert.True(t, errors.Is(err, ErrCertsUnavailable), "certs are needed before looking at the token, got %v", err)
+
+	// nothing has been loaded in the static provider yet
+	verifier = New(&StaticCertsProvider{})
+	_, err = verifier.VerifyE(signTestToken(t, testClaims(time.Now())), testAud)
+	assert.True(t, errors.Is(err, ErrCertsUnavailable), "got %v", err)
+	assert.Nil(t, verifier.Verify(signTestToken(t, testClaims(time.Now())), testAud))
+}
+
+func TestSentinelErrors(t *testing.T) {
+	verifier := New(&StaticCertsProvider{certs: loadTestCerts(t)})
+	authToken := signTestToken(t, testClaims(time.Now()))
+
+	otherSub := testClaims(time.Now())
+	otherSub["sub"] = "208426113748299532117"
+	tampered := strings.Split(signTestToken(t, otherSub), ".")
+	wrongAud := testClaims(time.Now())
+	wrongAud["aud"] = "other.apps.googleusercontent.com"
+	wrongIss := testClaims(time.Now())
+	wrongIss["iss"] = "https://evil.example.com"
+
+	tests := []struct {
+		testName string
+		token    string
+		expErr   error
+		expValue string
+	}{
+		{"Malformed token", "XXXXXXXXXXX.XXXXXXXXXXXX.XXXXXXXXXX", ErrMalformedToken, ""},
+		{"Invalid signature", tampered[0] + "." + strings.Split(authToken, ".")[1] + "." + tampered[2], ErrSignatureInvalid, ""},
+		{"Unknown kid", signTestTokenWithHeader(t, map[string]interface{}{"alg": "RS256", "kid": "unknown"}, testClaims(time.Now())), ErrKeyIDNotFound, `"unknown"`},
+		{"Audience mismatch", signTestToken(t, wrongAud), ErrAudienceMismatch, `"other.apps.googleusercontent.com"`},
+		{"Issuer mismatch", signTestToken(t, wrongIss), ErrIssuerMismatch, `"https://evil.example.com"`},
+		{"Expired", signTestToken(t, testClaims(time.Now().Add(-2*time.Hour))), ErrTokenExpired, ""},
+		{"Not yet valid", signTestToken(t, testClaims(time.Now().Add(time.Hour))), ErrTokenNotYetValid, ""},
+	}
+
+	for _, tc := range tests {
+		// nolint
+		t.Run(tc.testName, func(t *testing.T) {
+			_, err := verifier.VerifyE(tc.token, testAud)
+			require.Error(t, err)
+			assert.True(t, errors.Is(err, tc.expErr), "got %v", err)
+			assert.Contains(t, err.Error(), tc.expValue)
+		})
+	}
+}
+
+func TestNumericSubject(t *testing.T) {
+	certs := loadTestCerts(t)
+	verifier := New(&StaticCertsProvider{certs: certs}, WithNumericSubject())
+
+	tests := []struct {
+		testName string
+		sub      interface{}
+		expValid bool
+	}{
+		{"Numeric", "110169484474386276334", true},
+		{"Single digit", "0", true},
+		{"Letters", "user-110169484474386276334", false},
+		{"Negative", "-12", false},
+		{"Unicode digits", "١٢٣", false},
+	}
+
+	for _, tc := range tests {
+		// nolint
+		t.Run(tc.testName, func(t *testing.T) {
+			claims := testClaims(time.Now())
+			claims["sub"] = tc.sub
+			tokeninfo, err := verifier.VerifyE(signTestToken(t, claims), testAud)
+			if tc.expValid {
+				require.NoError(t, err)
+				assert.Equal(t, tc.sub, tokeninfo.Sub)
+				return
+			}
+			assert.Nil(t, tokeninfo)
+			assert.True(t, errors.Is(err, ErrInvalidSubjectFormat), "got %v", err)
+		})
+	}
+
+	// without the option any subject is accepted
+	claims := testClaims(time.Now())
+	claims["sub"] = "not-numeric"
+	assert.NotNil(t, New(&StaticCertsProvider{certs: certs}).Verify(signTestToken(t, claims), testAud))
+}
+
+func TestChoiceKeyByKeyID(t *testing.T) {
+	testKey := loadTestCerts(t).Keys[0]
+	otherKey := func(i int) Key {
+		return Key{Kty: "RSA", Alg: "RS256", Use: "sig", Kid: fmt.Sprintf("other-%d", i), N: testKey.N, E: testKey.E}
+	}
+
+	tests := []struct {
+		testName string
+		numKeys  int
+	}{
+		{"One key", 1},
+		{"Three keys", 3},
+		{"Five keys", 5},
+	}
+
+	for _, tc := range tests {
+		// nolint
+		t.Run(tc.testName, func(t *testing.T) {
+			for pos := 0; pos < tc.numKeys; pos++ {
+				keys := make([]Key, tc.numKeys)
+				for i := range keys {
+					keys[i] = otherKey(i)
+				}
+				keys[pos] = testKey
+
+				key, err := choiceKeyByKeyID(keys, testKeyID)
+				require.NoError(t, err)
+				assert.Equal(t, testKey, key)
+
+				verifier := New(&StaticCertsProvider{certs: &Certs{Keys: keys}})
+				assert.NotNil(t, verifier.Verify(signTestToken(t, testClaims(time.Now())), testAud))
+			}
+
+			keys := make([]Key, tc.numKeys)
+			for i := range keys {
+				keys[i] = otherKey(i)
+			}
+			_, err := choiceKeyByKeyID(keys, testKeyID)
+			assert.True(t, errors.Is(err, ErrKeyIDNotFound))
+		})
+	}
+}
+
+func TestBlockedEmailDomains(t *testing.T) {
+	verifier := New(&StaticCertsProvider{certs: loadTestCerts(t)}, WithBlockedEmailDomains("mailinator.com", "Guerrillamail.COM"))
+
+	tests := []struct {
+		testName      string
+		email         string
+		emailVerified bool
+		expBlocked    bool
+	}{
+		{"Allowed domain", "testuser@gmail.com", true, false},
+		{"Blocked domain", "testuser@mailinator.com", true, true},
+		{"Blocked domain other case", "testuser@MAILINATOR.com", true, true},
+		{"Blocked domain configured other case", "testuser@guerrillamail.com", true, true},
+		{"Subdomain of blocked domain", "testuser@eu.mailinator.com", true, false},
+		{"Blocked domain not verified", "testuser@mailinator.com", false, false},
+		{"No email", "", true, false},
+	}
+
+	for _, tc := range tests {
+		// nolint
+		t.Run(tc.testName, func(t *testing.T) {
+			claims := testClaims(time.Now())
+			claims["email"] = tc.email
+			claims["email_verified"] = tc.emailVerified
+			tokeninfo, err := verifier.VerifyE(signTestToken(t, claims), testAud)
+			if tc.expBlocked {
+				assert.Nil(t, tokeninfo)
+				assert.True(t, errors.Is(err, ErrEmailDomainBlocked), "got %v", err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.email, tokeninfo.Email)
+		})
+	}
+}
+
+func TestMissingSubject(t *testing.T) {
+	certs := loadTestCerts(t)
+
+	for _, sub := range []interface{}{nil, ""} {
+		claims := testClaims(time.Now())
+		delete(claims, "sub")
+		if sub != nil {
+			claims["sub"] = sub
+		}
+		authToken := signTestToken(t, claims)
+		tokeninfo, err := New(&StaticCertsProvider{certs: certs}).VerifyE(authToken, testAud)
+		assert.Nil(t, tokeninfo)
+		assert.True(t, errors.Is(err, ErrMissingSubject), "got %v", err)
+
+		// a missing subject is not reported as a non-numeric one
+		_, errs := New(&StaticCertsProvider{certs: certs}, WithNumericSubject()).VerifyAll(authToken, testAud)
+		require.Len(t, errs, 1)
+		assert.True(t, errors.Is(errs[0], ErrMissingSubject), "got %v", errs[0])
+	}
+}
+
+func TestRequireVerifiedEmail(t *testing.T) {
+	verifier := New(&StaticCertsProvider{certs: loadTestCerts(t)}, WithRequireVerifiedEmail())
+
+	tests := []struct {
+		testName      string
+		email         string
+		emailVerified bool
+		expValid      bool
+	}{
+		{"Verified email", "testuser@gmail.com", true, true},
+		{"Unverified email", "testuser@gmail.com", false, false},
+		{"No email", "", false, true},
+	}
+
+	for _, tc := range tests {
+		// nolint
+		t.Run(tc.testName, func(t *testing.T) {
+			claims := testClaims(time.Now())
+			delete(claims, "email")
+			delete(claims, "email_verified")
+			if tc.email != "" {
+				claims["email"] = tc.email
+				claims["email_verified"] = tc.emailVerified
+			}
+			authToken := signTestToken(t, claims)
+			tokeninfo, err := verifier.VerifyE(authToken, testAud)
+			if !tc.expValid {
+				assert.Nil(t, tokeninfo)
+				assert.True(t, errors.Is(err, ErrEmailNotVerified), "got %v", err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.email, tokeninfo.Email)
+		})
+	}
+
+	// off by default
+	claims := testClaims(time.Now())
+	claims["email_verified"] = false
+	_, err := New(&StaticCertsProvider{certs: loadTestCerts(t)}).VerifyE(signTestToken(t, claims), testAud)
+	assert.NoError(t, err)
+}
+
+func TestDivideAuthTokenSegments(t *testing.T) {
+	verifier := New(&StaticCertsProvider{certs: loadTestCerts(t)})
+	authToken := signTestToken(t, testClaims(time.Now()))
+	segments := strings.Split(authToken, ".")
+
+	tests := []struct {
+		testName string
+		token    string
+	}{
+		{"Empty string", ""},
+		{"One segment", "abc"},
+		{"Two segments", segments[0] + "." + segments[1]},
+		{"Four segments", authToken + "." + segments[2]},
+		{"Empty signature", segments[0] + "." + segments[1] + "."},
+		{"Illegal base64 character in payload", segments[0] + "." + segments[1][:10] + "*" + segments[1][11:] + "." + segments[2]},
+		{"Illegal base64 character in signature", segments[0] + "." + segments[1] + "." + segments[2][:10] + "!" + segments[2][11:]},
+		{"Only dots", ".."},
+	}
+
+	for _, tc := range tests {
+		// nolint
+		t.Run(tc.testName, func(t *testing.T) {
+			_, _, _, _, err := divideAuthToken(tc.token, false)
+			assert.True(t, errors.Is(err, ErrMalformedToken), "got %v", err)
+
+			tokeninfo, err := verifier.VerifyE(tc.token, testAud)
+			assert.Nil(t, tokeninfo)
+			assert.True(t, errors.Is(err, ErrMalformedToken), "got %v", err)
+		})
+	}
+}
+
+func TestRejectNonRS256Alg(t *testing.T) {
+	verifier := New(&StaticCertsProvider{certs: loadTestCerts(t)})
+
+	tests := []struct {
+		testName string
+		header   map[string]interface{}
+	}{
+		{"None", map[string]interface{}{"alg": "none", "kid": testKeyID}},
+		{"HS256", map[string]interface{}{"alg": "HS256", "kid": testKeyID}},
+		{"Lower case rs256", map[string]interface{}{"alg": "rs256", "kid": testKeyID}},
+		{"Missing alg", map[string]interface{}{"kid": testKeyID}},
+	}
+
+	for _, tc := range tests {
+		// nolint
+		t.Run(tc.testName, func(t *testing.T) {
+			// the token is correctly signed with RS256, only the header lies
+			tokeninfo, err := verifier.VerifyE(signTestTokenWithHeader(t, tc.header, testClaims(time.Now())), testAud)
+			assert.Nil(t, tokeninfo)
+			assert.True(t, errors.Is(err, ErrUnsupportedAlgorithm), "got %v", err)
+		})
+	}
+
+	// an unsigned token is refused as well
+	unsigned := strings.Split(signTestTokenWithHeader(t, map[string]interface{}{"alg": "none"}, testClaims(time.Now())), ".")
+	tokeninfo, err := verifier.VerifyE(unsigned[0]+"."+unsigned[1]+".AA", testAud)
+	assert.Nil(t, tokeninfo)
+	assert.True(t, errors.Is(err, ErrUnsupportedAlgorithm), "got %v", err)
+}
+
+func TestMaxClaimValueBytes(t *testing.T) {
+	verifier := New(&StaticCertsProvider{certs: loadTestCerts(t)}, WithMaxClaimValueBytes(256))
+	huge := strings.Repeat("x", 257)
+
+	tests := []struct {
+		testName string
+		claim    string
+		value    interface{}
+		expValid bool
+	}{
+		{"At the limit", "name", strings.Repeat("x", 256), true},
+		{"Oversized name", "name", huge, false},
+		{"Oversized custom claim", "custom", huge, false},
+		{"Oversized string in array", "roles", []string{"admin", huge}, false},
+		{"Oversized nested value", "profile", map[string]interface{}{"bio": huge}, false},
+		{"Oversized nested key", "profile", map[string]interface{}{huge: true}, false},
+	}
+
+	for _, tc := range tests {
+		// nolint
+		t.Run(tc.testName, func(t *testing.T) {
+			claims := testClaims(time.Now())
+			claims[tc.claim] = tc.value
+			tokeninfo, err := verifier.VerifyE(signTestToken(t, claims), testAud)
+			if tc.expValid {
+				assert.NoError(t, err)
+				assert.NotNil(t, tokeninfo)
+				return
+			}
+			assert.Nil(t, tokeninfo)
+			assert.True(t, errors.Is(err, ErrClaimTooLarge), "got %v", err)
+		})
+	}
+
+	claims := testClaims(time.Now())
+	claims["name"] = huge
+	assert.NotNil(t, New(&StaticCertsProvider{certs: loadTestCerts(t)}).Verify(signTestToken(t, claims), testAud))
+}
+
+func TestVerifyMulti(t *testing.T) {
+	verifier := New(&StaticCertsProvider{certs: loadTestCerts(t)})
+	auds := []string{"web.apps.googleusercontent.com", testAud, "ios.apps.googleusercontent.com"}
+
+	tokeninfo, err := verifier.VerifyMulti(signTestToken(t, testClaims(time.Now())), auds)
+	require.NoError(t, err)
+	assert.Equal(t, Audience{testAud}, tokeninfo.Aud)
+
+	tokeninfo, err = verifier.VerifyMulti(signTestToken(t, testClaims(time.Now())), auds[:1])
+	assert.Nil(t, tokeninfo)
+	assert.True(t, errors.Is(err, ErrAudienceMismatch), "got %v", err)
+
+	tokeninfo, err = verifier.VerifyMulti(signTestToken(t, testClaims(time.Now())), nil)
+	assert.Nil(t, tokeninfo)
+	assert.True(t, errors.Is(err, ErrAudienceMismatch), "got %v", err)
+}
+
+func TestStrictClientCheck(t *testing.T) {
+	const serverAud = "server.apps.googleusercontent.com"
+	const androidAzp = "android.apps.googleusercontent.com"
+	verifier := New(&StaticCertsProvider{certs: loadTestCerts(t)}, WithStrictClientCheck(testAud, androidAzp))
+
+	tests := []struct {
+		testName string
+		aud      string
+		azp      string
+		expValid bool
+	}{
+		{"Known aud and azp", testAud, testAud, true},
+		{"Known aud without azp", testAud, "", true},
+		{"Unknown aud without azp", serverAud, "", false},
+		{"Unknown aud with known azp", serverAud, androidAzp, true},
+		{"Known aud with unknown azp", testAud, "other.apps.googleusercontent.com", true},
+		{"Unknown aud and azp", serverAud, "other.apps.googleusercontent.com", false},
+	}
+
+	for _, tc := range tests {
+		// nolint
+		t.Run(tc.testName, func(t *testing.T) {
+			claims := testClaims(time.Now())
+			claims["aud"] = tc.aud
+			claims["azp"] = tc.azp
+			if tc.azp == "" {
+				delete(claims, "azp")
+			}
+			// the audience check passes, only the client check is tested
+			tokeninfo, err := verifier.VerifyE(signTestToken(t, claims), tc.aud)
+			if tc.expValid {
+				assert.NoError(t, err)
+				assert.NotNil(t, tokeninfo)
+				return
+			}
+			assert.Nil(t, tokeninfo)
+			assert.True(t, errors.Is(err, ErrClientIDMismatch), "got %v", err)
+		})
+	}
+}
+
+func TestLeeway(t *testing.T) {
+	certs := loadTestCerts(t)
+	now := time.Now()
+	expired := testClaims(now.Add(-time.Hour - 10*time.Second))
+	notYetValid := testClaims(now.Add(10 * time.Second))
+
+	tests := []struct {
+		testName string
+		opts     []Option
+		claims   map[string]interface{}
+		expErr   error
+	}{
+		{"Expired 10s ago, default leeway", nil, expired, nil},
+		{"Expired 10s ago, 30s leeway", []Option{WithLeeway(30 * time.Second)}, expired, nil},
+		{"Expired 10s ago, no leeway", []Option{WithLeeway(0)}, expired, ErrTokenExpired},
+		{"Expired 10s ago, 5s leeway", []Option{WithLeeway(5 * time.Second)}, expired, ErrTokenExpired},
+		{"Issued in 10s, 30s leeway", []Option{WithLeeway(30 * time.Second)}, notYetValid, nil},
+		{"Issued in 10s, no leeway", []Option{WithLeeway(0)}, notYetValid, ErrTokenNotYetValid},
+	}
+
+	for _, tc := range tests {
+		// nolint
+		t.Run(tc.testName, func(t *testing.T) {
+			verifier := New(&StaticCertsProvider{certs: certs}, tc.opts...)
+			tokeninfo, err := verifier.VerifyAsOf(signTestToken(t, tc.claims), testAud, now, certs)
+			if tc.expErr == nil {
+				assert.NoError(t, err)
+				assert.NotNil(t, tokeninfo)
+				return
+			}
+			assert.Nil(t, tokeninfo)
+			assert.True(t, errors.Is(err, tc.expErr), "got %v", err)
+		})
+	}
+}
+
+func TestMaxAge(t *testing.T) {
+	certs := loadTestCerts(t)
+	now := time.Now()
+	tenMinutesOld := signTestToken(t, testClaims(now.Add(-10*time.Minute)))
+	twoMinutesOld := signTestToken(t, testClaims(now.Add(-2*time.Minute)))
+
+	tests := []struct {
+		testName  string
+		opts      []Option
+		authToken string
+		expErr    error
+	}{
+		{"10 minutes old, 5 minutes max age", []Option{WithMaxAge(5 * time.Minute)}, tenMinutesOld, ErrTokenTooOld},
+		{"2 minutes old, 5 minutes max age", []Option{WithMaxAge(5 * time.Minute)}, twoMinutesOld, nil},
+		{"10 minutes old, no max age", nil, tenMinutesOld, nil},
+		{"5 minutes and 10s old, 5 minutes max age", []Option{WithMaxAge(5 * time.Minute)}, signTestToken(t, testClaims(now.Add(-5*time.Minute-10*time.Second))), nil},
+		{"5 minutes and 10s old, 5 minutes max age, no leeway", []Option{WithMaxAge(5 * time.Minute), WithLeeway(0)}, signTestToken(t, testClaims(now.Add(-5*time.Minute-10*time.Second))), ErrTokenTooOld},
+	}
+
+	for _, tc := range tests {
+		// nolint
+		t.Run(tc.testName, func(t *testing.T) {
+			verifier := New(&StaticCertsProvider{certs: certs}, tc.opts...)
+			tokeninfo, err := verifier.VerifyAsOf(tc.authToken, testAud, now, certs)
+			if tc.expErr == nil {
+				assert.NoError(t, err)
+				assert.NotNil(t, tokeninfo)
+				return
+			}
+			assert.Nil(t, tokeninfo)
+			assert.True(t, errors.Is(err, tc.expErr), "got %v", err)
+		})
+	}
+}
+
+func TestOutageExpiryGrace(t *testing.T) {
+	recentlyExpired := testClaims(time.Now().Add(-time.Hour - 2*time.Minute))
+	longExpired := testClaims(time.Now().Add(-time.Hour - 20*time.Minute))
+
+	// first certs are expired a minute ago, after that Google is down
+	outage := httptest.NewServer(appendHandlerFunc(
+		getTestCertsHandlerFunc(t, -time.Minute, nil),
+		getHandlerFunc(http.StatusInternalServerError, 0, nil),
+		new(int32)))
+	defer outage.Close()
+	degradedProv, err := newCachedURLCertsProvider(outage.URL, WithStaleGracePeriod(time.Hour))
+	require.NoError(t, err)
+
+	healthy := httptest.NewServer(getTestCertsHandlerFunc(t, time.Hour, nil))
+	defer healthy.Close()
+	healthyProv, err := newCachedURLCertsProvider(healthy.URL)
+	require.NoError(t, err)
+
+	tests := []struct {
+		testName string
+		prv      CertsProvider
+		opts     []Option
+		claims   map[string]interface{}
+		expValid bool
+	}{
+		{"Outage, recently expired", degradedProv, []Option{WithOutageExpiryGrace(10 * time.Minute)}, recentlyExpired, true},
+		{"Outage, expired beyond the grace", degradedProv, []Option{WithOutageExpiryGrace(10 * time.Minute)}, longExpired, false},
+		{"Outage without grace", degradedProv, nil, recentlyExpired, false},
+		{"Healthy provider", healthyProv, []Option{WithOutageExpiryGrace(10 * time.Minute)}, recentlyExpired, false},
+		{"Static provider", &StaticCertsProvider{certs: loadTestCerts(t)}, []Option{WithOutageExpiryGrace(10 * time.Minute)}, recentlyExpired, false},
+	}
+
+	for _, tc := range tests {
+		// nolint
+		t.Run(tc.testName, func(t *testing.T) {
+			verifier := New(tc.prv, tc.opts...)
+			tokeninfo, err := verifier.VerifyE(signTestToken(t, tc.claims), testAud)
+			if tc.expValid {
+				assert.NoError(t, err)
+				assert.NotNil(t, tokeninfo)
+				return
+			}
+			assert.Nil(t, tokeninfo)
+			assert.True(t, errors.Is(err, ErrTokenExpired), "got %v", err)
+		})
+	}
+}
+
+func TestHostedDomain(t *testing.T) {
+	verifier := New(&StaticCertsProvider{certs: loadTestCerts(t)}, WithHostedDomain("example.com"))
+
+	tests := []struct {
+		testName string
+		hd       string
+		expValid bool
+	}{
+		{"Hosted domain", "example.com", true},
+		{"Hosted domain other case", "Example.COM", true},
+		{"Other domain", "evil.com", false},
+		{"Subdomain", "eu.example.com", false},
+		{"Consumer account", "", false},
+	}
+
+	for _, tc := range tests {
+		// nolint
+		t.Run(tc.testName, func(t *testing.T) {
+			claims := testClaims(time.Now())
+			if tc.hd != "" {
+				claims["hd"] = tc.hd
+			}
+			tokeninfo, err := verifier.VerifyE(signTestToken(t, claims), testAud)
+			if tc.expValid {
+				require.NoError(t, err)
+				assert.Equal(t, tc.hd, tokeninfo.Hd)
+				return
+			}
+			assert.Nil(t, tokeninfo)
+			assert.True(t, errors.Is(err, ErrHostedDomainMismatch), "got %v", err)
+		})
+	}
+}
+
+func TestAuthorizedParty(t *testing.T) {
+	const androidClientID = "YYYYYYYYYYYYYYYYYYYYYYYYYYYYYYY.apps.googleusercontent.com"
+	verifier := New(&StaticCertsProvider{certs: loadTestCerts(t)}, WithAuthorizedParty(androidClientID))
+
+	tests := []struct {
+		testName string
+		azp      string
+		expValid bool
+	}{
+		{"Authorized party", androidClientID, true},
+		{"Audience as authorized party", testAud, false},
+		{"Other authorized party", "ZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZ.apps.googleusercontent.com", false},
+		{"No authorized party", "", false},
+	}
+
+	for _, tc := range tests {
+		// nolint
+		t.Run(tc.testName, func(t *testing.T) {
+			claims := testClaims(time.Now())
+			delete(claims, "azp")
+			if tc.azp != "" {
+				claims["azp"] = tc.azp
+			}
+			tokeninfo, err := verifier.VerifyE(signTestToken(t, claims), testAud)
+			if tc.expValid {
+				require.NoError(t, err)
+				assert.Equal(t, tc.azp, tokeninfo.Azp)
+				return
+			}
+			assert.Nil(t, tokeninfo)
+			assert.True(t, errors.Is(err, ErrAuthorizedPartyMismatch), "got %v", err)
+		})
+	}
+
+	// the azp is not checked by default
+	tokeninfo, err := New(&StaticCertsProvider{certs: loadTestCerts(t)}).VerifyE(signTestToken(t, testClaims(time.Now())), testAud)
+	require.NoError(t, err)
+	assert.Equal(t, testAud, tokeninfo.Azp)
+}
+
+func TestStrictHeaderType(t *testing.T) {
+	certs := loadTestCerts(t)
+	strict := New(&StaticCertsProvider{certs: certs}, WithStrictHeaderType("JWT"))
+	lenient := New(&StaticCertsProvider{certs: certs})
+
+	tests := []struct {
+		testName string
+		verifier *GoogleTokenVerifier
+		typ      interface{}
+		expValid bool
+	}{
+		{"JWT", strict, "JWT", true},
+		{"Lower case", strict, "jwt", true},
+		{"Media type", strict, "application/jwt", true},
+		{"Access token", strict, "at+jwt", false},
+		{"Other media type", strict, "application/at+jwt", false},
+		{"Absent", strict, nil, false},
+		{"Access token without option", lenient, "at+jwt", true},
+		{"Absent without option", lenient, nil, true},
+	}
+
+	for _, tc := range tests {
+		// nolint
+		t.Run(tc.testName, func(t *testing.T) {
+			header := map[string]interface{}{"alg": "RS256", "kid": testKeyID}
+			if tc.typ != nil {
+				header["typ"] = tc.typ
+			}
+			tokeninfo, err := tc.verifier.VerifyE(signTestTokenWithHeader(t, header, testClaims(time.Now())), testAud)
+			if tc.expValid {
+				require.NoError(t, err)
+				assert.NotNil(t, tokeninfo)
+				return
+			}
+			assert.Nil(t, tokeninfo)
+			assert.True(t, errors.Is(err, ErrHeaderTypeMismatch), "got %v", err)
+		})
+	}
+}
+
+func TestVerifyWithNonce(t *testing.T) {
+	verifier := New(&StaticCertsProvider{certs: loadTestCerts(t)})
+	const nonce = "0394852-3190485-2490358"
+
+	tests := []struct {
+		testName string
+		nonce    string
+		expValid bool
+	}{
+		{"Matching nonce", nonce, true},
+		{"Mismatched nonce", "0394852-3190485-2490359", false},
+		{"Missing nonce", "", false},
+	}
+
+	for _, tc := range tests {
+		// nolint
+		t.Run(tc.testName, func(t *testing.T) {
+			claims := testClaims(time.Now())
+			if tc.nonce != "" {
+				claims["nonce"] = tc.nonce
+			}
+			authToken := signTestToken(t, claims)
+			tokeninfo, err := verifier.VerifyWithNonce(authToken, testAud, nonce)
+			if tc.expValid {
+				require.NoError(t, err)
+				assert.Equal(t, nonce, tokeninfo.Nonce)
+			} else {
+				assert.Nil(t, tokeninfo)
+				assert.True(t, errors.Is(err, ErrNonceMismatch), "got %v", err)
+			}
+			// the nonce is not checked by the verifier itself
+			assert.NotNil(t, verifier.Verify(authToken, testAud))
+		})
+	}
+
+	_, err := verifier.VerifyWithNonce(signTestToken(t, testClaims(time.Now())), testAud, "")
+	assert.True(t, errors.Is(err, ErrNonceMismatch), "got %v", err)
+}
+
+func TestVerifyInto(t *testing.T) {
+	verifier := New(&StaticCertsProvider{certs: loadTestCerts(t)})
+	type myClaims struct {
+		Subject    string    `json:"sub"`
+		Email      string    `json:"email"`
+		Department string    `json:"department"`
+		Roles      []string  `json:"roles"`
+		Expiry     int64     `json:"exp"`
+		Audience   *Audience `json:"aud"`
+	}
+	claims := testClaims(time.Now())
+	claims["department"] = "engineering"
+	claims["roles"] = []string{"admin", "billing"}
+
+	var dest myClaims
+	require.NoError(t, verifier.VerifyInto(signTestToken(t, claims), testAud, &dest))
+	assert.Equal(t, "110169484474386276334", dest.Subject)
+	assert.Equal(t, "testuser@gmail.com", dest.Email)
+	assert.Equal(t, "engineering", dest.Department)
+	assert.Equal(t, []string{"admin", "billing"}, dest.Roles)
+	assert.Equal(t, claims["exp"], dest.Expiry)
+	assert.Equal(t, &Audience{testAud}, dest.Audience)
+
+	// invalid tokens never populate dest
+	dest = myClaims{}
+	err := verifier.VerifyInto(signTestToken(t, claims), "other.apps.googleusercontent.com", &dest)
+	assert.True(t, errors.Is(err, ErrAudienceMismatch), "got %v", err)
+	assert.Equal(t, myClaims{}, dest)
+
+	var wrongType struct {
+		Department int `json:"department"`
+	}
+	err = verifier.VerifyInto(signTestToken(t, claims), testAud, &wrongType)
+	var verr *VerifyError
+	require.True(t, errors.As(err, &verr))
+	assert.Equal(t, MalformedToken, verr.Kind)
+}
+
+func TestVerifyContext(t *testing.T) {
+	authToken := signTestToken(t, testClaims(time.Now()))
+	verifier := New(&StaticCertsProvider{certs: loadTestCerts(t)})
+	tokeninfo, err := verifier.VerifyContext(context.Background(), authToken, testAud)
+	require.NoError(t, err)
+	assert.NotNil(t, tokeninfo)
+
+	// nothing loaded yet, the first request is slow
+	ts := httptest.NewServer(getSlowHandlerFunc(http.StatusOK, time.Hour*2, nil, 500*time.Millisecond))
+	defer ts.Close()
+	verifier = New(newUnloadedCertsProvider(ts.URL, defaultRefreshBefore))
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	tokeninfo, err = verifier.VerifyContext(ctx, authToken, testAud)
+	assert.Less(t, int64(time.Since(start)), int64(400*time.Millisecond))
+	assert.Nil(t, tokeninfo)
+	assert.True(t, errors.Is(err, context.DeadlineExceeded), "got %v", err)
+	var verr *VerifyError
+	require.True(t, errors.As(err, &verr))
+	assert.Equal(t, CertsUnavailable, verr.Kind)
+}
+
+// failingTransport fails every request, as when the network is down
+type failingTransport struct{}
+
+func (failingTransport) RoundTrip(*http.Request) (*http.Response, error) {
+	return nil, errors.New("network is down")
+}
+
+func TestGetCertsFromURLFailure(t *testing.T) {
+	client := defaultHTTPClient
+	defer func() { defaultHTTPClient = client }()
+	defaultHTTPClient = &http.Client{Transport: failingTransport{}}
+	assert.Nil(t, GetCertsFromURL())
 }